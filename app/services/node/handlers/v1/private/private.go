@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	v1 "github.com/ardanlabs/blockchain/business/web/v1"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
@@ -13,6 +14,7 @@ import (
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ardanlabs/blockchain/foundation/web"
+	"github.com/ethereum/go-ethereum/rlp"
 	"go.uber.org/zap"
 )
 
@@ -31,19 +33,20 @@ func (h Handlers) SubmitNodeTransaction(ctx context.Context, w http.ResponseWrit
 	}
 
 	var tx storage.BlockTx
-	if err := web.Decode(r, &tx); err != nil {
+	if err := decode(w, r, &tx); err != nil {
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
 	h.Log.Infow("add user tran", "traceid", v.TraceID, "from:nonce", tx, "to", tx.To, "value", tx.Value, "tip", tx.Tip)
-	if err := h.State.SubmitNodeTransaction(tx); err != nil {
+	status, err := h.State.SubmitNodeTransaction(tx)
+	if err != nil {
 		return v1.NewRequestError(err, http.StatusBadRequest)
 	}
 
 	resp := struct {
 		Status string `json:"status"`
 	}{
-		Status: "transactions added to mempool",
+		Status: fmt.Sprintf("transaction %s in mempool", status),
 	}
 
 	return web.Respond(ctx, w, resp, http.StatusOK)
@@ -53,23 +56,13 @@ func (h Handlers) SubmitNodeTransaction(ctx context.Context, w http.ResponseWrit
 // to the block chain.
 func (h Handlers) MinePeerBlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var block storage.Block
-	if err := web.Decode(r, &block); err != nil {
+	if err := decode(w, r, &block); err != nil {
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
+	// If the block shows the chain has forked, the state will reorganize
+	// itself to the longer chain in the background.
 	if err := h.State.MinePeerBlock(block); err != nil {
-
-		// More has to be thought about here. I don't think the blockchain
-		// package can perform this activity because it doesn't understand
-		// the application layer. All activity needs to stop after this call
-		// to truncate to re-sync the state of the blockchain.
-		// So the idea for now is to truncate the state here and force a
-		// shutdown/restart of the service.
-		if errors.Is(err, state.ErrChainForked) {
-			h.State.Truncate()
-			return web.NewShutdownError(err.Error())
-		}
-
 		return v1.NewRequestError(err, http.StatusNotAcceptable)
 	}
 
@@ -91,6 +84,7 @@ func (h Handlers) Status(ctx context.Context, w http.ResponseWriter, r *http.Req
 	status := peer.PeerStatus{
 		LatestBlockHash:   latestBlock.Hash(),
 		LatestBlockNumber: latestBlock.Header.Number,
+		LatestBlockWork:   h.State.RetrieveLatestWork(),
 		KnownPeers:        h.State.RetrieveKnownPeers(),
 	}
 
@@ -127,11 +121,49 @@ func (h Handlers) BlocksByNumber(ctx context.Context, w http.ResponseWriter, r *
 		return web.Respond(ctx, w, nil, http.StatusNoContent)
 	}
 
-	return web.Respond(ctx, w, dbBlocks, http.StatusOK)
+	return respond(ctx, w, r, dbBlocks, http.StatusOK)
 }
 
 // Mempool returns the set of uncommitted transactions.
 func (h Handlers) Mempool(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	txs := h.State.RetrieveMempool()
-	return web.Respond(ctx, w, txs, http.StatusOK)
+	return respond(ctx, w, r, txs, http.StatusOK)
+}
+
+// =============================================================================
+
+// decode reads the body of the request as RLP when the content type asks for
+// it, otherwise as JSON. The body is limited to the size of the largest block
+// so a peer can't make the node read an unbounded amount of data.
+func decode(w http.ResponseWriter, r *http.Request, val interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, storage.MaxBlockBytes)
+
+	if r.Header.Get("Content-Type") != storage.ContentTypeRLP {
+		return web.Decode(r, val)
+	}
+
+	return rlp.NewStream(r.Body, storage.MaxBlockBytes).Decode(val)
+}
+
+// respond sends the data back as RLP when the client accepts it, otherwise
+// as JSON. JSON stays the default so the API is easy to use for debugging.
+func respond(ctx context.Context, w http.ResponseWriter, r *http.Request, data interface{}, statusCode int) error {
+	if !strings.Contains(r.Header.Get("Accept"), storage.ContentTypeRLP) {
+		return web.Respond(ctx, w, data, statusCode)
+	}
+
+	rlpData, err := rlp.EncodeToBytes(data)
+	if err != nil {
+		return err
+	}
+
+	web.SetStatusCode(ctx, statusCode)
+	w.Header().Set("Content-Type", storage.ContentTypeRLP)
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(rlpData); err != nil {
+		return err
+	}
+
+	return nil
 }
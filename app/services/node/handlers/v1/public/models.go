@@ -1,6 +1,7 @@
 package public
 
 import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 )
 
@@ -13,6 +14,7 @@ type info struct {
 
 type actInfo struct {
 	LastestBlock string `json:"lastest_block"`
+	BlockNumber  uint64 `json:"block_number"`
 	Uncommitted  int    `json:"uncommitted"`
 	Accounts     []info `json:"accounts"`
 }
@@ -39,6 +41,29 @@ type block struct {
 	TotalTip     uint            `json:"total_tip"`
 	TotalGas     uint            `json:"total_gas"`
 	TimeStamp    uint64          `json:"timestamp"`
+	TransRoot    string          `json:"trans_root"`
+	StateRoot    string          `json:"state_root"`
 	Nonce        uint64          `json:"nonce"`
 	Transactions []tx            `json:"txs"`
 }
+
+type txProof struct {
+	BlockNumber uint64              `json:"block_number"`
+	BlockHash   string              `json:"block_hash"`
+	TransRoot   string              `json:"trans_root"`
+	TxHash      string              `json:"tx_hash"`
+	Tx          storage.BlockTx     `json:"tx"`
+	Proof       []merkle.Step       `json:"proof"`
+	Header      storage.BlockHeader `json:"header"`
+}
+
+type actProof struct {
+	Account     storage.Account `json:"account"`
+	Name        string          `json:"name"`
+	Balance     uint            `json:"balance"`
+	Nonce       uint            `json:"nonce"`
+	BlockNumber uint64          `json:"block_number"`
+	BlockHash   string          `json:"block_hash"`
+	StateRoot   string          `json:"state_root"`
+	Proof       []merkle.Step   `json:"proof"`
+}
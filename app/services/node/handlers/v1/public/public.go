@@ -3,8 +3,10 @@ package public
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	v1 "github.com/ardanlabs/blockchain/business/web/v1"
@@ -79,14 +81,15 @@ func (h Handlers) SubmitWalletTransaction(ctx context.Context, w http.ResponseWr
 	}
 
 	h.Log.Infow("add user tran", "traceid", v.TraceID, "from:nonce", signedTx, "to", signedTx.To, "value", signedTx.Value, "tip", signedTx.Tip)
-	if err := h.State.SubmitWalletTransaction(signedTx); err != nil {
+	status, err := h.State.SubmitWalletTransaction(signedTx)
+	if err != nil {
 		return v1.NewRequestError(err, http.StatusBadRequest)
 	}
 
 	resp := struct {
 		Status string `json:"status"`
 	}{
-		Status: "transactions added to mempool",
+		Status: fmt.Sprintf("transaction %s in mempool", status),
 	}
 
 	return web.Respond(ctx, w, resp, http.StatusOK)
@@ -129,9 +132,25 @@ func (h Handlers) Mempool(ctx context.Context, w http.ResponseWriter, r *http.Re
 	return web.Respond(ctx, w, trans, http.StatusOK)
 }
 
-// Accounts returns the current balances for all users.
+// Accounts returns the current balances for all users. When a block number
+// is provided, the balances are returned as they were after that block.
 func (h Handlers) Accounts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	account := web.Param(r, "account")
+	var account storage.Account
+	if act := web.Param(r, "account"); act != "" {
+		var err error
+		if account, err = storage.ToAccount(act); err != nil {
+			return err
+		}
+	}
+
+	if blockStr := r.URL.Query().Get("block"); blockStr != "" {
+		number, err := strconv.ParseUint(blockStr, 10, 64)
+		if err != nil {
+			return v1.NewRequestError(err, http.StatusBadRequest)
+		}
+
+		return h.accountsAt(ctx, w, account, number)
+	}
 
 	var blkAccounts map[storage.Account]accounts.Info
 	switch account {
@@ -139,13 +158,43 @@ func (h Handlers) Accounts(ctx context.Context, w http.ResponseWriter, r *http.R
 		blkAccounts = h.State.RetrieveAccounts()
 
 	default:
-		account, err := storage.ToAccount(account)
-		if err != nil {
-			return err
-		}
 		blkAccounts = h.State.QueryAccounts(account)
 	}
 
+	latestBlock := h.State.RetrieveLatestBlock()
+
+	ai := actInfo{
+		LastestBlock: latestBlock.Hash(),
+		BlockNumber:  latestBlock.Header.Number,
+		Uncommitted:  len(h.State.RetrieveMempool()),
+		Accounts:     h.toInfo(blkAccounts),
+	}
+
+	return web.Respond(ctx, w, ai, http.StatusOK)
+}
+
+// accountsAt responds with the balances as they were after the block with the
+// specified number.
+func (h Handlers) accountsAt(ctx context.Context, w http.ResponseWriter, account storage.Account, number uint64) error {
+	block, blkAccounts, err := h.State.QueryAccountsAt(account, number)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return v1.NewRequestError(fmt.Errorf("block %d not found", number), http.StatusNotFound)
+		}
+		return err
+	}
+
+	ai := actInfo{
+		LastestBlock: block.Hash(),
+		BlockNumber:  number,
+		Accounts:     h.toInfo(blkAccounts),
+	}
+
+	return web.Respond(ctx, w, ai, http.StatusOK)
+}
+
+// toInfo converts the account information into the response model.
+func (h Handlers) toInfo(blkAccounts map[storage.Account]accounts.Info) []info {
 	acts := make([]info, 0, len(blkAccounts))
 	for account, blkInfo := range blkAccounts {
 		act := info{
@@ -157,13 +206,38 @@ func (h Handlers) Accounts(ctx context.Context, w http.ResponseWriter, r *http.R
 		acts = append(acts, act)
 	}
 
-	ai := actInfo{
-		LastestBlock: h.State.RetrieveLatestBlock().Hash(),
-		Uncommitted:  len(h.State.RetrieveMempool()),
-		Accounts:     acts,
+	return acts
+}
+
+// AccountProof returns the information for the specified account along with
+// a merkle proof the information is included in the state root of the
+// latest block.
+func (h Handlers) AccountProof(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	account, err := storage.ToAccount(web.Param(r, "account"))
+	if err != nil {
+		return v1.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	return web.Respond(ctx, w, ai, http.StatusOK)
+	blk, blkInfo, proof, err := h.State.QueryAccountProof(account)
+	if err != nil {
+		if errors.Is(err, accounts.ErrNotFound) {
+			return v1.NewRequestError(err, http.StatusNotFound)
+		}
+		return err
+	}
+
+	resp := actProof{
+		Account:     account,
+		Name:        h.NS.Lookup(account),
+		Balance:     blkInfo.Balance,
+		Nonce:       blkInfo.Nonce,
+		BlockNumber: blk.Header.Number,
+		BlockHash:   blk.Hash(),
+		StateRoot:   blk.Header.StateRoot,
+		Proof:       proof,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
 }
 
 // BlocksByAccount returns all the blocks and their details.
@@ -206,6 +280,8 @@ func (h Handlers) BlocksByAccount(ctx context.Context, w http.ResponseWriter, r
 			TotalTip:     blk.Header.TotalTip,
 			TotalGas:     blk.Header.TotalGas,
 			TimeStamp:    blk.Header.TimeStamp,
+			TransRoot:    blk.Header.TransRoot,
+			StateRoot:    blk.Header.StateRoot,
 			Nonce:        blk.Header.Nonce,
 			Transactions: trans,
 		}
@@ -215,3 +291,36 @@ func (h Handlers) BlocksByAccount(ctx context.Context, w http.ResponseWriter, r
 
 	return web.Respond(ctx, w, blocks, http.StatusOK)
 }
+
+// TransactionProof returns a merkle proof that the transaction with the
+// specified signature is included in a block. The proof can be verified
+// against the transaction root in the block header.
+func (h Handlers) TransactionProof(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sig := web.Param(r, "sig")
+
+	blk, index, err := h.State.QueryBlockByTransaction(sig)
+	if err != nil {
+		if errors.Is(err, state.ErrTransactionNotFound) {
+			return v1.NewRequestError(err, http.StatusNotFound)
+		}
+		return err
+	}
+
+	proof, err := storage.TransProof(blk.Transactions, index)
+	if err != nil {
+		return err
+	}
+
+	tran := blk.Transactions[index]
+	resp := txProof{
+		BlockNumber: blk.Header.Number,
+		BlockHash:   blk.Hash(),
+		TransRoot:   blk.Header.TransRoot,
+		TxHash:      tran.Hash(),
+		Tx:          tran,
+		Proof:       proof,
+		Header:      blk.Header,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"net/http"
@@ -14,7 +15,10 @@ import (
 	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/disk"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
 	"github.com/ardanlabs/blockchain/foundation/events"
+	"github.com/ardanlabs/blockchain/foundation/keystore"
 	"github.com/ardanlabs/blockchain/foundation/logger"
 	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ardanlabs/conf/v3"
@@ -61,12 +65,24 @@ func run(log *zap.SugaredLogger) error {
 		Node struct {
 			MinerName      string   `conf:"default:miner1"`
 			DBPath         string   `conf:"default:zblock/blocks.db"`
+			Storage        string   `conf:"default:disk,help:disk, rlp or memory"`
 			SelectStrategy string   `conf:"default:Tip"`
+			ReplaceTipBump uint     `conf:"default:10"`
 			KnownPeers     []string `conf:"default:0.0.0.0:9080;0.0.0.0:9180"`
 		}
+		Mempool struct {
+			MaxSize       int           `conf:"default:5000"`
+			MaxPerAccount int           `conf:"default:64"`
+			TTL           time.Duration `conf:"default:3h"`
+		}
 		NameService struct {
 			Folder string `conf:"default:zblock/accounts/"`
 		}
+		Keystore struct {
+			Passphrase      string `conf:"mask"`
+			PassphraseFile  string
+			DevPlaintextKey bool `conf:"help:load the miner key from a plaintext .ecdsa file for development"`
+		}
 	}{
 		Version: conf.Version{
 			Build: build,
@@ -106,7 +122,11 @@ func run(log *zap.SugaredLogger) error {
 	// =========================================================================
 	// Name Service Support
 
-	ns, err := nameservice.New(cfg.NameService.Folder)
+	nsEv := func(v string, args ...interface{}) {
+		log.Infow("startup", "status", fmt.Sprintf(v, args...))
+	}
+
+	ns, err := nameservice.New(cfg.NameService.Folder, nsEv)
 	if err != nil {
 		return fmt.Errorf("unable to load account name service: %w", err)
 	}
@@ -118,10 +138,33 @@ func run(log *zap.SugaredLogger) error {
 	// =========================================================================
 	// Blockchain Support
 
-	path := fmt.Sprintf("%s%s.ecdsa", cfg.NameService.Folder, cfg.Node.MinerName)
-	privateKey, err := crypto.LoadECDSA(path)
-	if err != nil {
-		return fmt.Errorf("unable to load private key for node: %w", err)
+	// The miner key is kept in a keystore file encrypted with a passphrase.
+	// Plaintext key files are only for development.
+	var privateKey *ecdsa.PrivateKey
+	switch {
+	case cfg.Keystore.DevPlaintextKey:
+		log.Infow("startup", "status", "WARNING: loading plaintext miner key, for development only")
+
+		path := fmt.Sprintf("%s%s.ecdsa", cfg.NameService.Folder, cfg.Node.MinerName)
+		if privateKey, err = crypto.LoadECDSA(path); err != nil {
+			return fmt.Errorf("unable to load private key for node: %w", err)
+		}
+
+	default:
+		passphrase := cfg.Keystore.Passphrase
+		if cfg.Keystore.PassphraseFile != "" {
+			if passphrase, err = keystore.ReadPassphrase(cfg.Keystore.PassphraseFile); err != nil {
+				return fmt.Errorf("unable to read keystore passphrase: %w", err)
+			}
+		}
+		if passphrase == "" {
+			return errors.New("keystore passphrase required: set NODE_KEYSTORE_PASSPHRASE or --keystore-passphrase-file")
+		}
+
+		path := fmt.Sprintf("%s%s.json", cfg.NameService.Folder, cfg.Node.MinerName)
+		if privateKey, err = keystore.Unlock(path, passphrase); err != nil {
+			return fmt.Errorf("unable to unlock private key for node: %w", err)
+		}
 	}
 
 	account := storage.PublicKeyToAccount(privateKey.PublicKey)
@@ -139,12 +182,43 @@ func run(log *zap.SugaredLogger) error {
 		evts.Send(s)
 	}
 
+	// Construct the storage for the blockchain. Disk storage writes blocks
+	// as JSON or as the more compact RLP. Nothing is kept between runs of the
+	// node with memory storage.
+	var strg storage.Storage
+	switch cfg.Node.Storage {
+	case "disk", "rlp":
+		encoding := storage.EncodingJSON
+		if cfg.Node.Storage == "rlp" {
+			encoding = storage.EncodingRLP
+		}
+
+		dsk, err := disk.New(cfg.Node.DBPath, encoding, ev)
+		if err != nil {
+			return fmt.Errorf("unable to open blockchain storage: %w", err)
+		}
+		strg = dsk
+
+	case "memory":
+		strg = memory.New()
+		cfg.Node.DBPath = ""
+
+	default:
+		return fmt.Errorf("unknown storage %q", cfg.Node.Storage)
+	}
+
 	state, err := state.New(state.Config{
-		MinerAccount: account,
-		Host:         cfg.Web.PrivateHost,
-		DBPath:       cfg.Node.DBPath,
-		KnownPeers:   peerSet,
-		EvHandler:    ev,
+		MinerAccount:         account,
+		Host:                 cfg.Web.PrivateHost,
+		DBPath:               cfg.Node.DBPath,
+		Storage:              strg,
+		SelectStrategy:       cfg.Node.SelectStrategy,
+		ReplaceTipBump:       cfg.Node.ReplaceTipBump,
+		MempoolMaxSize:       cfg.Mempool.MaxSize,
+		MempoolMaxPerAccount: cfg.Mempool.MaxPerAccount,
+		MempoolTTL:           cfg.Mempool.TTL,
+		KnownPeers:           peerSet,
+		EvHandler:            ev,
 	})
 	if err != nil {
 		return err
@@ -1,4 +1,5 @@
 var nonce = 0;
+var chainID = 0;
 
 window.onload = function () {
     wireEvents();
@@ -190,12 +191,26 @@ function load() {
     nonce = 0;
     document.getElementById("tranbutton").innerHTML = "Trans";
 
+    genesis();
     fromBalance();
     toBalance();
     transactions();
     mempool();
 }
 
+function genesis() {
+    $.ajax({
+        type: "get",
+        url: "http://localhost:8080/v1/genesis/list",
+        success: function (resp) {
+            chainID = Number(resp.chain_id);
+        },
+        error: function (jqXHR, exception) {
+            handleAjaxError(jqXHR, exception);
+        },
+    });
+}
+
 function fromBalance() {
     const wallet = new ethers.Wallet(document.getElementById("from").value);
 
@@ -306,7 +321,15 @@ function submitTran() {
         showMessage("No connection to node.");
         return;
     }
-    
+
+    // The chain id is loaded from the node with the genesis information and
+    // must be signed into the transaction, so wait until it has arrived.
+    if (chainID == 0) {
+        genesis();
+        showMessage("Still loading the chain id from the node, try again.");
+        return;
+    }
+
     // Update the account information.
     fromBalance();
 
@@ -353,6 +376,7 @@ function createTransaction() {
 
      // Construct a userTx with all the information.
      const userTx = {
+        chain_id: chainID,
         nonce: nonce,
         to: document.getElementById("to").value,
         value: Number(amountStr),
@@ -360,9 +384,16 @@ function createTransaction() {
         data: null,
     };
 
-    // Marshal the userTx to a string and convert the string to bytes.
-    const marshal = JSON.stringify(userTx);
-    const marshalBytes = ethers.utils.toUtf8Bytes(marshal);
+    // Serialize the userTx into its canonical RLP form. The fields must be
+    // in this order for the node to produce the same bytes.
+    const marshalBytes = ethers.utils.RLP.encode([
+        rlpUint(userTx.chain_id),
+        rlpUint(userTx.nonce),
+        ethers.utils.hexlify(ethers.utils.toUtf8Bytes(userTx.to)),
+        rlpUint(userTx.value),
+        rlpUint(userTx.tip),
+        "0x",
+    ]);
 
     // Hash the transaction data into a 32 byte array. This will provide
 	// a data length consistency with all transactions.
@@ -379,6 +410,13 @@ function createTransaction() {
     signature.then((sig) => sendTran(userTx, sig));
 }
 
+// rlpUint returns the canonical bytes for an unsigned integer: big endian
+// with no leading zeros, so zero has no bytes at all.
+function rlpUint(n) {
+    const bytes = ethers.utils.arrayify(ethers.BigNumber.from(n).toHexString());
+    return ethers.utils.hexlify(ethers.utils.stripZeros(bytes));
+}
+
 function sendTran(userTx, sig) {
 
     // Need to break out the R and S bytes from the signature.
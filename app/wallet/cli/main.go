@@ -2,13 +2,22 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/keystore"
+	"github.com/ardanlabs/blockchain/foundation/wallet"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -16,19 +25,41 @@ var to = flag.String("t", "", "to")
 var nonce = flag.Uint("n", 0, "nonce")
 var value = flag.Uint("v", 0, "value")
 var tip = flag.Uint("p", 0, "tip")
+var account = flag.String("a", "kennedy", "account name in zblock/accounts")
+var passFile = flag.String("passfile", "", "file holding the keystore passphrase, otherwise $WALLET_PASSPHRASE")
+var dev = flag.Bool("dev", false, "use a plaintext .ecdsa key and mnemonic file, for development only")
+var ks = flag.String("keystore", "", "keystore operation: create, import or export")
+var mnemonicFile = flag.String("mnemonic", "", "file holding the mnemonic phrase to derive accounts from")
+var index = flag.Uint("i", 0, "index of the account derived from the mnemonic")
+var hd = flag.String("hd", "", "mnemonic operation: create or list")
+var count = flag.Uint("count", 10, "number of derived accounts to list")
 
 func main() {
 	flag.Parse()
 
-	err := sendTran()
+	var err error
+	switch {
+	case *ks != "":
+		err = keystoreOp(*ks)
+	case *hd != "":
+		err = mnemonicOp(*hd)
+	default:
+		err = sendTran()
+	}
+
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
 
 func sendTran() error {
+	privateKey, err := loadKey()
+	if err != nil {
+		return err
+	}
 
-	privateKey, err := crypto.LoadECDSA("zblock/accounts/kennedy.ecdsa")
+	// The transaction is signed for the chain listed in the genesis file.
+	gen, err := genesis.Load()
 	if err != nil {
 		return err
 	}
@@ -38,7 +69,7 @@ func sendTran() error {
 		log.Fatal(err)
 	}
 
-	userTx, err := storage.NewUserTx(*nonce, toAccount, *value, *tip, nil)
+	userTx, err := storage.NewUserTx(gen.ChainID, *nonce, toAccount, *value, *tip, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -60,18 +91,175 @@ func sendTran() error {
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+
 	return nil
 }
 
-func genkey() error {
-	privateKey, err := crypto.GenerateKey()
+// keystoreOp performs the keystore operation for the account. Import reads
+// the plaintext .ecdsa key and export writes it.
+func keystoreOp(op string) error {
+	passphrase, err := readPassphrase()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := crypto.SaveECDSA("./bill.ecdsa", privateKey); err != nil {
-		log.Fatal(err)
+	ecdsaPath, keyPath := keyPaths()
+
+	var privateKey *ecdsa.PrivateKey
+	switch op {
+	case "create":
+		privateKey, err = keystore.Create(keyPath, passphrase)
+	case "import":
+		privateKey, err = keystore.Import(ecdsaPath, keyPath, passphrase)
+	case "export":
+		privateKey, err = keystore.Export(keyPath, passphrase, ecdsaPath)
+	default:
+		return fmt.Errorf("unknown keystore operation %q", op)
 	}
 
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %s\n", *account, storage.PublicKeyToAccount(privateKey.PublicKey))
+
 	return nil
 }
+
+// mnemonicOp performs the mnemonic operation. Create writes a new phrase to
+// the mnemonic file encrypted with the keystore passphrase, since the phrase
+// recovers every derived account.
+func mnemonicOp(op string) error {
+	if *mnemonicFile == "" {
+		return errors.New("mnemonic file required: set -mnemonic")
+	}
+
+	switch op {
+	case "create":
+		if _, err := os.Stat(*mnemonicFile); err == nil {
+			return fmt.Errorf("file %q already exists", *mnemonicFile)
+		}
+
+		mnemonic, err := wallet.NewMnemonic()
+		if err != nil {
+			return err
+		}
+
+		if *dev {
+			if err := os.WriteFile(*mnemonicFile, []byte(mnemonic+"\n"), 0600); err != nil {
+				return err
+			}
+			break
+		}
+
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return err
+		}
+
+		if err := keystore.StoreSecret(*mnemonicFile, []byte(mnemonic), passphrase); err != nil {
+			return err
+		}
+
+	case "list":
+		// The accounts are listed below.
+
+	default:
+		return fmt.Errorf("unknown mnemonic operation %q", op)
+	}
+
+	w, err := loadWallet()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < uint32(*count); i++ {
+		acct, err := w.Account(i)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d: %s %s\n", i, wallet.Path(i), acct)
+	}
+
+	return nil
+}
+
+// loadWallet unlocks the mnemonic phrase in the mnemonic file, or reads the
+// plaintext phrase in development.
+func loadWallet() (*wallet.Wallet, error) {
+	var data []byte
+	switch {
+	case *dev:
+		var err error
+		if data, err = os.ReadFile(*mnemonicFile); err != nil {
+			return nil, err
+		}
+
+	default:
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = keystore.UnlockSecret(*mnemonicFile, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	return wallet.New(strings.TrimSpace(string(data)), "")
+}
+
+// loadKey derives the private key for the account at the index from the
+// mnemonic, unlocks it from the keystore, or loads the plaintext key in
+// development.
+func loadKey() (*ecdsa.PrivateKey, error) {
+	if *mnemonicFile != "" {
+		w, err := loadWallet()
+		if err != nil {
+			return nil, err
+		}
+
+		return w.PrivateKey(uint32(*index))
+	}
+
+	ecdsaPath, keyPath := keyPaths()
+
+	if *dev {
+		return crypto.LoadECDSA(ecdsaPath)
+	}
+
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	return keystore.Unlock(keyPath, passphrase)
+}
+
+// keyPaths returns the plaintext and keystore file names for the account.
+func keyPaths() (ecdsaPath string, keyPath string) {
+	base := filepath.Join("zblock/accounts", *account)
+	return base + ".ecdsa", base + ".json"
+}
+
+// readPassphrase returns the keystore passphrase from the passphrase file
+// or the environment.
+func readPassphrase() (string, error) {
+	passphrase := os.Getenv("WALLET_PASSPHRASE")
+	if *passFile != "" {
+		var err error
+		if passphrase, err = keystore.ReadPassphrase(*passFile); err != nil {
+			return "", err
+		}
+	}
+
+	if passphrase == "" {
+		return "", errors.New("keystore passphrase required: set WALLET_PASSPHRASE or -passfile")
+	}
+
+	return passphrase, nil
+}
@@ -2,17 +2,24 @@
 package accounts
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 )
 
+// ErrNotFound is returned when an account is not found.
+var ErrNotFound = errors.New("account not found")
+
 // Info represents information stored for an individual account.
 type Info struct {
-	Balance uint
-	Nonce   uint
+	Balance uint `json:"balance"`
+	Nonce   uint `json:"nonce"`
 }
 
 // Accounts manages data related to accounts who have transacted on
@@ -56,6 +63,48 @@ func (act *Accounts) Remove(account storage.Account) {
 	delete(act.info, account)
 }
 
+// Clone makes a copy of the accounts that can be changed without affecting
+// the original. This is used to validate changes before they are applied.
+func (act *Accounts) Clone() *Accounts {
+	act.mu.RLock()
+	defer act.mu.RUnlock()
+
+	cpy := Accounts{
+		genesis: act.genesis,
+		info:    make(map[storage.Account]Info, len(act.info)),
+	}
+	for account, info := range act.info {
+		cpy.info[account] = info
+	}
+
+	return &cpy
+}
+
+// Replace sets the information for all accounts to match the specified
+// accounts. This is used to apply changes that were validated on a clone.
+func (act *Accounts) Replace(from *Accounts) {
+	info := from.Copy()
+
+	act.mu.Lock()
+	defer act.mu.Unlock()
+
+	act.info = info
+}
+
+// Restore sets the information for all accounts to the specified
+// information. This is used to start from a snapshot of the accounts.
+func (act *Accounts) Restore(info map[storage.Account]Info) {
+	accounts := make(map[storage.Account]Info, len(info))
+	for account, inf := range info {
+		accounts[account] = inf
+	}
+
+	act.mu.Lock()
+	defer act.mu.Unlock()
+
+	act.info = accounts
+}
+
 // Copy makes a copy of the current information for all accounts.
 func (act *Accounts) Copy() map[storage.Account]Info {
 	act.mu.RLock()
@@ -68,6 +117,64 @@ func (act *Accounts) Copy() map[storage.Account]Info {
 	return accounts
 }
 
+// Balance returns the current balance for the account.
+func (act *Accounts) Balance(account storage.Account) uint {
+	act.mu.RLock()
+	defer act.mu.RUnlock()
+
+	return act.info[account].Balance
+}
+
+// Nonce returns the nonce of the last transaction applied for the account.
+func (act *Accounts) Nonce(account storage.Account) uint {
+	act.mu.RLock()
+	defer act.mu.RUnlock()
+
+	return act.info[account].Nonce
+}
+
+// StateRoot calculates the merkle root over the information for all accounts
+// sorted by account. Each leaf of the tree is the account, balance and nonce
+// formatted as "account:balance:nonce".
+func (act *Accounts) StateRoot() string {
+	act.mu.RLock()
+	defer act.mu.RUnlock()
+
+	leaves, _ := act.leaves("")
+	return hex.EncodeToString(merkle.Root(leaves))
+}
+
+// Proof returns the information for the specified account along with the
+// merkle proof that the information is included in the state root.
+func (act *Accounts) Proof(account storage.Account) (Info, []merkle.Step, error) {
+	act.mu.RLock()
+	defer act.mu.RUnlock()
+
+	info, exists := act.info[account]
+	if !exists {
+		return Info{}, nil, ErrNotFound
+	}
+
+	leaves, index := act.leaves(account)
+	proof, err := merkle.Proof(leaves, index)
+	if err != nil {
+		return Info{}, nil, err
+	}
+
+	return info, proof, nil
+}
+
+// VerifyProof checks the merkle proof shows the account has the specified
+// information in the state with the specified root.
+func VerifyProof(account storage.Account, info Info, proof []merkle.Step, stateRoot string) bool {
+	root, err := hex.DecodeString(stateRoot)
+	if err != nil {
+		return false
+	}
+
+	return merkle.Verify(leaf(account, info), proof, root)
+}
+
 // ValidateNonce validates the nonce for the specified transaction is larger
 // than the last nonce used by the account who signed the transaction.
 func (act *Accounts) ValidateNonce(tx storage.SignedTx) error {
@@ -84,7 +191,7 @@ func (act *Accounts) ValidateNonce(tx storage.SignedTx) error {
 	act.mu.RUnlock()
 
 	if tx.Nonce <= info.Nonce {
-		return fmt.Errorf("invalid nonce, got %d, exp > %d", tx.Nonce, info.Nonce)
+		return fmt.Errorf("invalid nonce, %d has already been used by %s, exp > %d", tx.Nonce, from, info.Nonce)
 	}
 
 	return nil
@@ -117,31 +224,60 @@ func (act *Accounts) ApplyTransaction(minerAccount storage.Account, tx storage.B
 		}
 
 		fromInfo := act.info[from]
-		if tx.Nonce < fromInfo.Nonce {
-			return fmt.Errorf("invalid transaction, nonce too small, last %d, tx %d", fromInfo.Nonce, tx.Nonce)
+		if tx.Nonce != fromInfo.Nonce+1 {
+			return fmt.Errorf("invalid transaction, nonce out of order, last %d, tx %d", fromInfo.Nonce, tx.Nonce)
 		}
 
 		fee := tx.Gas + tx.Tip
 
-		if tx.Value+fee > act.info[from].Balance {
+		// Check each amount on its own so large values can't overflow.
+		if fee < tx.Gas || tx.Value > fromInfo.Balance || fee > fromInfo.Balance-tx.Value {
 			return fmt.Errorf("%s has an insufficient balance", from)
 		}
 
-		toInfo := act.info[tx.To]
-		minerInfo := act.info[minerAccount]
+		// The miner can also be the sender or the receiver, so each account
+		// is written back before the next one is read.
+		fromInfo.Balance -= tx.Value + fee
+		fromInfo.Nonce = tx.Nonce
+		act.info[from] = fromInfo
 
-		fromInfo.Balance -= tx.Value
+		toInfo := act.info[tx.To]
 		toInfo.Balance += tx.Value
+		act.info[tx.To] = toInfo
 
-		fromInfo.Balance -= fee
+		minerInfo := act.info[minerAccount]
 		minerInfo.Balance += fee
-
-		fromInfo.Nonce = tx.Nonce
-
-		act.info[from] = fromInfo
-		act.info[tx.To] = toInfo
 		act.info[minerAccount] = minerInfo
 	}
 
 	return nil
 }
+
+// =============================================================================
+
+// leaves returns the merkle leaves for all accounts sorted by account, along
+// with the index of the leaf for the specified account. The caller must hold
+// the accounts lock.
+func (act *Accounts) leaves(account storage.Account) ([][]byte, int) {
+	accounts := make([]storage.Account, 0, len(act.info))
+	for acct := range act.info {
+		accounts = append(accounts, acct)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+
+	var index int
+	leaves := make([][]byte, len(accounts))
+	for i, acct := range accounts {
+		if acct == account {
+			index = i
+		}
+		leaves[i] = leaf(acct, act.info[acct])
+	}
+
+	return leaves, index
+}
+
+// leaf returns the merkle leaf for the account information.
+func leaf(account storage.Account, info Info) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%d", account, info.Balance, info.Nonce))
+}
@@ -0,0 +1,184 @@
+package accounts_test
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/accounts"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestApplyTransaction(t *testing.T) {
+	fromKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a private key: %s", err)
+	}
+	toKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a private key: %s", err)
+	}
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a private key: %s", err)
+	}
+
+	from := storage.PublicKeyToAccount(fromKey.PublicKey)
+	to := storage.PublicKeyToAccount(toKey.PublicKey)
+	other := storage.PublicKeyToAccount(otherKey.PublicKey)
+
+	const (
+		balance = 1000
+		value   = 100
+		tip     = 10
+		gas     = 15
+		fee     = tip + gas
+	)
+
+	tt := []struct {
+		name    string
+		miner   storage.Account
+		expFrom uint
+		expTo   uint
+		expMine uint
+	}{
+		{"miner is another account", other, balance - value - fee, value, fee},
+		{"miner is the sender", from, balance - value, value, balance - value},
+		{"miner is the receiver", to, balance - value - fee, value + fee, value + fee},
+	}
+
+	t.Log("Given the need to apply a transaction to the accounts.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				gen := genesis.Genesis{
+					ChainID:  1,
+					Balances: map[storage.Account]uint{from: balance},
+				}
+				act := accounts.New(gen)
+
+				userTx, err := storage.NewUserTx(gen.ChainID, 1, to, value, tip, nil)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to construct the transaction: %s", failed, testID, err)
+				}
+				signedTx, err := userTx.Sign(fromKey)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to sign the transaction: %s", failed, testID, err)
+				}
+
+				if err := act.ApplyTransaction(test.miner, storage.NewBlockTx(signedTx, gas)); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to apply the transaction: %s", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to apply the transaction.", success, testID)
+
+				info := act.Copy()
+
+				if got := info[from].Balance; got != test.expFrom {
+					t.Fatalf("\t%s\tTest %d:\tShould have the sender balance: got %d, exp %d", failed, testID, got, test.expFrom)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the sender balance.", success, testID)
+
+				if got := info[to].Balance; got != test.expTo {
+					t.Fatalf("\t%s\tTest %d:\tShould have the receiver balance: got %d, exp %d", failed, testID, got, test.expTo)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the receiver balance.", success, testID)
+
+				if got := info[test.miner].Balance; got != test.expMine {
+					t.Fatalf("\t%s\tTest %d:\tShould have the miner balance: got %d, exp %d", failed, testID, got, test.expMine)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the miner balance.", success, testID)
+
+				if got := info[from].Nonce; got != 1 {
+					t.Fatalf("\t%s\tTest %d:\tShould have the sender nonce: got %d, exp %d", failed, testID, got, 1)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the sender nonce.", success, testID)
+			}
+		}
+	}
+}
+
+func TestProof(t *testing.T) {
+	accts := []storage.Account{
+		"0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9",
+		"0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76",
+		"0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		"0xdd6B972ffcc631a62CAE1BB9d80b7ff429c8ebA4",
+	}
+	const unknown = storage.Account("0xF01813E4B85e178A83e29B8E7bF26BD830a25f32")
+
+	t.Log("Given the need to prove the information for an account is in the state root.")
+	{
+		for testID := range accts {
+			count := testID + 1
+
+			t.Logf("\tTest %d:\tWhen there are %d accounts.", testID, count)
+			{
+				gen := genesis.Genesis{
+					MiningReward: 700,
+					Balances:     make(map[storage.Account]uint),
+				}
+				for i, acct := range accts[:count] {
+					gen.Balances[acct] = uint(100 * (i + 1))
+				}
+				act := accounts.New(gen)
+				stateRoot := act.StateRoot()
+
+				for _, acct := range accts[:count] {
+					info, proof, err := act.Proof(acct)
+					if err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to get the proof for %s: %s", failed, testID, acct, err)
+					}
+
+					if info.Balance != gen.Balances[acct] {
+						t.Fatalf("\t%s\tTest %d:\tShould get the balance for %s: got %d, exp %d", failed, testID, acct, info.Balance, gen.Balances[acct])
+					}
+
+					if !accounts.VerifyProof(acct, info, proof, stateRoot) {
+						t.Fatalf("\t%s\tTest %d:\tShould verify the proof for %s.", failed, testID, acct)
+					}
+
+					tampered := info
+					tampered.Balance++
+					if accounts.VerifyProof(acct, tampered, proof, stateRoot) {
+						t.Fatalf("\t%s\tTest %d:\tShould not verify a changed balance for %s.", failed, testID, acct)
+					}
+
+					tampered = info
+					tampered.Nonce++
+					if accounts.VerifyProof(acct, tampered, proof, stateRoot) {
+						t.Fatalf("\t%s\tTest %d:\tShould not verify a changed nonce for %s.", failed, testID, acct)
+					}
+
+					if accounts.VerifyProof(unknown, info, proof, stateRoot) {
+						t.Fatalf("\t%s\tTest %d:\tShould not verify the information for %s under another account.", failed, testID, acct)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould verify the proof for every account.", success, testID)
+				t.Logf("\t%s\tTest %d:\tShould not verify changed information.", success, testID)
+
+				if _, _, err := act.Proof(unknown); err != accounts.ErrNotFound {
+					t.Fatalf("\t%s\tTest %d:\tShould not get a proof for an unknown account: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould not get a proof for an unknown account.", success, testID)
+
+				info, proof, err := act.Proof(accts[0])
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to get the proof: %s", failed, testID, err)
+				}
+
+				act.ApplyMiningReward(accts[0])
+				if accounts.VerifyProof(accts[0], info, proof, act.StateRoot()) {
+					t.Fatalf("\t%s\tTest %d:\tShould not verify an old proof against the new state root.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould not verify an old proof against the new state root.", success, testID)
+			}
+		}
+	}
+}
@@ -10,13 +10,15 @@ import (
 
 // Genesis represents the genesis file.
 type Genesis struct {
-	Date          time.Time                `json:"date"`
-	ChainID       string                   `json:"chain_id"`
-	Difficulty    int                      `json:"difficulty"`             // How difficult it needs to be to solve the work problem.
-	TransPerBlock int                      `json:"transactions_per_block"` // Number of transactions recorded in every block.
-	MiningReward  uint                     `json:"mining_reward"`          // Reward for mining a block.
-	GasPrice      uint                     `json:"gas_price"`              // Fee paid for each transaction mined into a block.
-	Balances      map[storage.Account]uint `json:"balances"`
+	Date             time.Time                `json:"date"`
+	ChainID          uint16                   `json:"chain_id"`               // Identifies the network transactions are signed for.
+	Difficulty       int                      `json:"difficulty"`             // Number of leading zero bits a hash needs for the first blocks.
+	TargetBlockTime  uint64                   `json:"target_block_time"`      // Number of seconds it should take to mine a block.
+	RetargetInterval uint64                   `json:"retarget_interval"`      // Number of blocks between difficulty adjustments.
+	TransPerBlock    int                      `json:"transactions_per_block"` // Number of transactions recorded in every block.
+	MiningReward     uint                     `json:"mining_reward"`          // Reward for mining a block.
+	GasPrice         uint                     `json:"gas_price"`              // Fee paid for each transaction mined into a block.
+	Balances         map[storage.Account]uint `json:"balances"`
 }
 
 // Load opens and consumes the genesis file.
@@ -0,0 +1,129 @@
+package mempool
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// JournalTx is a transaction kept in the journal along with the time it
+// arrived at this node.
+type JournalTx struct {
+	storage.BlockTx
+	Arrived int64 `json:"arrived"` // Unix time the transaction arrived at this node.
+}
+
+// journal keeps a file of the transactions added to the mempool so they can
+// be loaded again when the node restarts. Transactions are only ever
+// appended, so the file is rotated from time to time to drop transactions
+// that are no longer in the mempool. The file is synced when it's rotated and
+// closed but not on every insert, so a crash can lose the transactions added
+// since the last rotate. Those can be sent to the node again.
+type journal struct {
+	path string
+	file *os.File
+}
+
+// ReadJournal reads the transactions from the journal file in the order they
+// were added. A missing file has no transactions. If the file ends with a
+// partial transaction, the transactions read before it are returned along
+// with the error.
+func ReadJournal(path string) ([]JournalTx, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var trans []JournalTx
+	decoder := json.NewDecoder(file)
+	for {
+		var tx JournalTx
+		if err := decoder.Decode(&tx); err != nil {
+			if errors.Is(err, io.EOF) {
+				return trans, nil
+			}
+			return trans, err
+		}
+		trans = append(trans, tx)
+	}
+}
+
+// insert appends the transaction to the journal. The write isn't synced.
+func (jnl *journal) insert(tx JournalTx) error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = jnl.file.Write(append(data, '\n'))
+	return err
+}
+
+// rotate replaces the journal file with one holding only the specified
+// transactions and opens it to append new transactions.
+func (jnl *journal) rotate(trans []JournalTx) error {
+	if jnl.file != nil {
+		jnl.file.Close()
+		jnl.file = nil
+	}
+
+	tmpPath := jnl.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range trans {
+		data, err := json.Marshal(tx)
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		if _, err := tmpFile.Write(append(data, '\n')); err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, jnl.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(jnl.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	jnl.file = file
+
+	return nil
+}
+
+// close syncs and releases the journal file.
+func (jnl *journal) close() error {
+	if jnl.file == nil {
+		return nil
+	}
+
+	err := jnl.file.Sync()
+	if cerr := jnl.file.Close(); err == nil {
+		err = cerr
+	}
+	jnl.file = nil
+
+	return err
+}
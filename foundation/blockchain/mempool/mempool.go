@@ -1,22 +1,120 @@
 package mempool
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 )
 
-// Mempool represents a cache of transactions organized by account:nonce.
+// ErrUnderpriced is returned when a transaction replaces one in the mempool
+// with the same nonce without raising the tip enough.
+var ErrUnderpriced = errors.New("replacement transaction underpriced")
+
+// ErrFull is returned when the mempool is full and the transaction doesn't
+// pay a higher tip than any transaction that could be evicted.
+var ErrFull = errors.New("mempool is full")
+
+// ErrAccountLimit is returned when an account already has the maximum number
+// of transactions allowed in the mempool.
+var ErrAccountLimit = errors.New("account has too many transactions in the mempool")
+
+// ErrExpired is returned when a transaction has been in the mempool longer
+// than the TTL.
+var ErrExpired = errors.New("transaction has expired")
+
+// ErrFutureTimestamp is returned when a transaction has a timestamp too far
+// ahead of this node's clock.
+var ErrFutureTimestamp = errors.New("transaction timestamp is in the future")
+
+// maxTimeDrift represents how far into the future a transaction's timestamp
+// can be compared to this node's clock.
+const maxTimeDrift = 2 * time.Minute
+
+// Status represents the result of adding a transaction to the mempool.
+type Status string
+
+// Set of results from adding a transaction to the mempool.
+const (
+	StatusInserted Status = "inserted"
+	StatusReplaced Status = "replaced"
+)
+
+// NonceFunc returns the nonce of the last transaction mined for the account.
+type NonceFunc func(account storage.Account) uint
+
+// EventHandler defines a function that is called when transactions are
+// evicted from the mempool.
+type EventHandler func(v string, args ...interface{})
+
+// Config represents the configuration required to start the mempool. A
+// zero value for MaxSize, MaxPerAccount or TTL means there is no limit.
+type Config struct {
+	SelectStrategy string
+	ReplaceTipBump uint          // Percentage a replacement must raise the tip by.
+	MaxSize        int           // Maximum number of transactions in the pool.
+	MaxPerAccount  int           // Maximum number of transactions per account.
+	TTL            time.Duration // How long a transaction can stay in the pool.
+	JournalPath    string        // File to keep the transactions in, empty for none.
+	NonceFunc      NonceFunc
+	EvHandler      EventHandler
+}
+
+// Mempool represents a cache of transactions organized by account. The
+// transactions for an account are split between a pending list that has no
+// gaps from the account's last mined nonce and can be mined now, and a queued
+// set that is waiting on earlier nonces. The time each transaction arrived at
+// this node is kept for the TTL, since the timestamp in a transaction from a
+// peer can't be trusted.
 type Mempool struct {
-	pool map[string]storage.BlockTx
-	mu   sync.RWMutex
+	pending   map[storage.Account][]storage.BlockTx
+	queued    map[storage.Account]map[uint]storage.BlockTx
+	arrived   map[storage.Account]map[uint]time.Time
+	mu        sync.RWMutex
+	tipBump   uint
+	maxSize   int
+	maxPerAct int
+	ttl       time.Duration
+	journal   *journal
+	nonceFn   NonceFunc
+	selectFn  selector.Func
+	evHandler EventHandler
 }
 
-// New constructs a new mempool with specified sort strategy.
-func New() (*Mempool, error) {
+// New constructs a new mempool with specified sort strategy. The nonce
+// function is used to decide which transactions are pending.
+func New(cfg Config) (*Mempool, error) {
+	selectFn, err := selector.Retrieve(cfg.SelectStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build a safe event handler function for use.
+	ev := func(v string, args ...interface{}) {
+		if cfg.EvHandler != nil {
+			cfg.EvHandler(v, args...)
+		}
+	}
+
 	mp := Mempool{
-		pool: make(map[string]storage.BlockTx),
+		pending:   make(map[storage.Account][]storage.BlockTx),
+		queued:    make(map[storage.Account]map[uint]storage.BlockTx),
+		arrived:   make(map[storage.Account]map[uint]time.Time),
+		tipBump:   cfg.ReplaceTipBump,
+		maxSize:   cfg.MaxSize,
+		maxPerAct: cfg.MaxPerAccount,
+		ttl:       cfg.TTL,
+		nonceFn:   cfg.NonceFunc,
+		selectFn:  selectFn,
+		evHandler: ev,
+	}
+
+	if cfg.JournalPath != "" {
+		mp.journal = &journal{path: cfg.JournalPath}
 	}
 
 	return &mp, nil
@@ -27,77 +125,410 @@ func (mp *Mempool) Count() int {
 	mp.mu.RLock()
 	defer mp.mu.RUnlock()
 
-	return len(mp.pool)
+	return mp.count()
 }
 
-// Upsert adds or replaces a transaction from the mempool.
-func (mp *Mempool) Upsert(tx storage.BlockTx) (int, error) {
+// CountPending returns the current number of transactions in the pool that
+// can be mined.
+func (mp *Mempool) CountPending() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.countPending()
+}
+
+// Upsert adds or replaces a transaction from the mempool. A transaction with
+// the same account and nonce is only replaced if the tip is raised by the
+// configured percentage. When the pool is full, the transaction with the
+// lowest tip is evicted to make room. The number of transactions that can be
+// mined is returned along with the result.
+func (mp *Mempool) Upsert(tx storage.BlockTx) (Status, int, error) {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
-	key, err := mapKey(tx)
-	if err != nil {
-		return 0, err
-	}
+	return mp.upsert(tx, time.Now())
+}
 
-	mp.pool[key] = tx
+// UpsertJournal adds a transaction read from the journal like Upsert. The
+// time the transaction first arrived is kept, so restarting the node doesn't
+// restart the TTL.
+func (mp *Mempool) UpsertJournal(jtx JournalTx) (Status, int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
 
-	return len(mp.pool), nil
+	return mp.upsert(jtx.BlockTx, time.Unix(jtx.Arrived, 0))
 }
 
-// Delete removed a transaction from the mempool.
+// Delete removed a transaction from the mempool. Any pending transactions
+// for the account with a larger nonce are moved back to the queue.
 func (mp *Mempool) Delete(tx storage.BlockTx) error {
 	mp.mu.Lock()
 	defer mp.mu.Unlock()
 
-	key, err := mapKey(tx)
+	account, err := tx.FromAccount()
 	if err != nil {
 		return err
 	}
 
-	delete(mp.pool, key)
+	trans := mp.collect(account)
+	delete(trans, tx.Nonce)
+	mp.arrange(account, trans)
 
 	return nil
 }
 
-// Copy uses the configured sort strategy to return the next set
-// of transactions for the next block.
-func (mp *Mempool) Copy() []storage.BlockTx {
+// Expire removes the transactions that have been in the mempool longer than
+// the configured TTL. The number of transactions removed is returned.
+func (mp *Mempool) Expire() int {
+	if mp.ttl == 0 {
+		return 0
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	now := time.Now()
+
+	var expired int
+	for _, account := range mp.accounts() {
+		trans := mp.collect(account)
+		before := len(trans)
+		for nonce, tx := range trans {
+			if arrived := mp.arrived[account][nonce]; mp.expired(arrived, now) {
+				mp.evHandler("mempool: expire: tx[%s]: arrived at %d", tx, arrived.Unix())
+				delete(trans, nonce)
+			}
+		}
+
+		if len(trans) != before {
+			expired += before - len(trans)
+			mp.arrange(account, trans)
+		}
+	}
+
+	return expired
+}
+
+// Promote rearranges the transactions for every account after the account
+// nonces have changed. Transactions that have already been mined are dropped
+// and queued transactions with no remaining gap are made pending.
+func (mp *Mempool) Promote() {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, account := range mp.accounts() {
+		mp.arrange(account, mp.collect(account))
+	}
+}
+
+// ByAccount returns the transactions in the pool signed by the account,
+// both pending and queued, ordered by nonce.
+func (mp *Mempool) ByAccount(account storage.Account) []storage.BlockTx {
 	mp.mu.RLock()
 	defer mp.mu.RUnlock()
 
-	cpy := []storage.BlockTx{}
-	for _, tx := range mp.pool {
-		cpy = append(cpy, tx)
+	trans := make([]storage.BlockTx, 0, len(mp.pending[account])+len(mp.queued[account]))
+	trans = append(trans, mp.pending[account]...)
+	for _, tx := range mp.queued[account] {
+		trans = append(trans, tx)
 	}
-	return cpy
+	sort.Slice(trans, func(i, j int) bool { return trans[i].Nonce < trans[j].Nonce })
+
+	return trans
+}
+
+// Rotate rewrites the journal to hold only the transactions currently in the
+// pool. Transactions added after the first rotate are written to the journal.
+func (mp *Mempool) Rotate() error {
+	if mp.journal == nil {
+		return nil
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	trans := mp.all()
+	jtxs := make([]JournalTx, len(trans))
+	for i, tx := range trans {
+		account, err := tx.FromAccount()
+		if err != nil {
+			return err
+		}
+		jtxs[i] = JournalTx{BlockTx: tx, Arrived: mp.arrived[account][tx.Nonce].Unix()}
+	}
+
+	return mp.journal.rotate(jtxs)
+}
+
+// Close releases the journal.
+func (mp *Mempool) Close() error {
+	if mp.journal == nil {
+		return nil
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.journal.close()
+}
+
+// Copy returns all the transactions in the pool in the order of the
+// configured sort strategy.
+func (mp *Mempool) Copy() []storage.BlockTx {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.all()
 }
 
 // PickBest uses the configured sort strategy to return the next set
-// of transactions for the next block.
+// of transactions for the next block. Only pending transactions are
+// picked. Passing -1 for howMany returns all the pending transactions.
 func (mp *Mempool) PickBest(howMany int) []storage.BlockTx {
 	mp.mu.RLock()
 	defer mp.mu.RUnlock()
 
-	cpy := []storage.BlockTx{}
-	for _, tx := range mp.pool {
-		cpy = append(cpy, tx)
-		if len(cpy) == howMany {
-			break
-		}
-	}
-
-	return cpy
+	return mp.selectFn(mp.pending, howMany)
 }
 
 // =============================================================================
 
-// mapKey is used to generate the map key.
-func mapKey(tx storage.BlockTx) (string, error) {
+// upsert adds or replaces a transaction that arrived at the specified time.
+// The caller must hold the mempool lock.
+func (mp *Mempool) upsert(tx storage.BlockTx, arrived time.Time) (Status, int, error) {
 	account, err := tx.FromAccount()
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+
+	if nonce := mp.nonceFn(account); tx.Nonce <= nonce {
+		return "", 0, fmt.Errorf("nonce %d has already been mined for account %s, last %d", tx.Nonce, account, nonce)
+	}
+
+	now := time.Now()
+
+	if limit := uint64(now.Add(maxTimeDrift).UTC().Unix()); tx.TimeStamp > limit {
+		return "", 0, fmt.Errorf("%w: got %d, exp <= %d", ErrFutureTimestamp, tx.TimeStamp, limit)
+	}
+
+	if mp.expired(arrived, now) {
+		return "", 0, fmt.Errorf("%w: arrived at %d", ErrExpired, arrived.Unix())
+	}
+
+	status := StatusInserted
+
+	trans := mp.collect(account)
+	switch current, exists := trans[tx.Nonce]; {
+	case exists:
+		if minTip := mp.replaceTip(current.Tip); tx.Tip < minTip {
+			return "", 0, fmt.Errorf("%w: tip %d, exp >= %d", ErrUnderpriced, tx.Tip, minTip)
+		}
+		status = StatusReplaced
+
+	case mp.maxPerAct > 0 && len(trans) >= mp.maxPerAct:
+		return "", 0, fmt.Errorf("%w: account %s, limit %d", ErrAccountLimit, account, mp.maxPerAct)
+
+	case mp.maxSize > 0 && mp.count() >= mp.maxSize:
+		if err := mp.evict(tx); err != nil {
+			return "", 0, err
+		}
+		trans = mp.collect(account)
+	}
+	trans[tx.Nonce] = tx
+	if mp.arrived[account] == nil {
+		mp.arrived[account] = make(map[uint]time.Time)
+	}
+	mp.arrived[account][tx.Nonce] = arrived
+	mp.arrange(account, trans)
+
+	if mp.journal != nil && mp.journal.file != nil {
+		if err := mp.journal.insert(JournalTx{BlockTx: tx, Arrived: arrived.Unix()}); err != nil {
+			mp.evHandler("mempool: journal: tx[%s]: ERROR: %s", tx, err)
+		}
+	}
+
+	return status, mp.countPending(), nil
+}
+
+// all returns all the transactions in the pool in the order of the configured
+// sort strategy. The caller must hold the mempool lock.
+func (mp *Mempool) all() []storage.BlockTx {
+	all := make(map[storage.Account][]storage.BlockTx)
+	for account, trans := range mp.pending {
+		all[account] = append(all[account], trans...)
+	}
+	for account, trans := range mp.queued {
+		for _, tx := range trans {
+			all[account] = append(all[account], tx)
+		}
 	}
 
-	return fmt.Sprintf("%s:%d", account, tx.Nonce), nil
+	return mp.selectFn(all, -1)
+}
+
+// replaceTip returns the minimum tip needed to replace a transaction with the
+// specified tip. When a bump is required, the tip must be raised by at least 1.
+func (mp *Mempool) replaceTip(tip uint) uint {
+	if mp.tipBump == 0 {
+		return tip
+	}
+
+	bump := tip/100*mp.tipBump + tip%100*mp.tipBump/100
+	if bump == 0 {
+		bump = 1
+	}
+
+	return tip + bump
+}
+
+// evict removes the transaction with the lowest tip to make room for the
+// specified transaction. Only the last pending transaction for an account
+// and queued transactions can be evicted, so pending lists stay free of
+// gaps. The caller must hold the mempool lock.
+func (mp *Mempool) evict(tx storage.BlockTx) error {
+	var lowest storage.BlockTx
+	var lowestAccount storage.Account
+	var lowestArrived time.Time
+	var found bool
+	consider := func(account storage.Account, candidate storage.BlockTx) {
+		arrived := mp.arrived[account][candidate.Nonce]
+
+		// With equal tips, the most recently arrived transaction goes.
+		if found && (candidate.Tip > lowest.Tip || (candidate.Tip == lowest.Tip && !arrived.After(lowestArrived))) {
+			return
+		}
+		lowest = candidate
+		lowestAccount = account
+		lowestArrived = arrived
+		found = true
+	}
+
+	for account, trans := range mp.pending {
+		consider(account, trans[len(trans)-1])
+	}
+	for account, trans := range mp.queued {
+		for _, candidate := range trans {
+			consider(account, candidate)
+		}
+	}
+
+	if !found || tx.Tip <= lowest.Tip {
+		return fmt.Errorf("%w: tip %d, exp > %d", ErrFull, tx.Tip, lowest.Tip)
+	}
+
+	mp.evHandler("mempool: evict: tx[%s]: tip[%d]: mempool full", lowest, lowest.Tip)
+
+	trans := mp.collect(lowestAccount)
+	delete(trans, lowest.Nonce)
+	mp.arrange(lowestAccount, trans)
+
+	return nil
+}
+
+// expired checks if a transaction that arrived at the specified time has
+// been in the pool longer than the configured TTL.
+func (mp *Mempool) expired(arrived time.Time, now time.Time) bool {
+	if mp.ttl == 0 {
+		return false
+	}
+
+	return now.Sub(arrived) > mp.ttl
+}
+
+// accounts returns the set of accounts with transactions in the pool. The
+// caller must hold the mempool lock.
+func (mp *Mempool) accounts() []storage.Account {
+	accounts := make([]storage.Account, 0, len(mp.pending)+len(mp.queued))
+	for account := range mp.pending {
+		accounts = append(accounts, account)
+	}
+	for account := range mp.queued {
+		if _, exists := mp.pending[account]; !exists {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return accounts
+}
+
+// count returns the number of transactions. The caller must hold the
+// mempool lock.
+func (mp *Mempool) count() int {
+	var count int
+	for _, trans := range mp.pending {
+		count += len(trans)
+	}
+	for _, trans := range mp.queued {
+		count += len(trans)
+	}
+
+	return count
+}
+
+// countPending returns the number of pending transactions. The caller must
+// hold the mempool lock.
+func (mp *Mempool) countPending() int {
+	var count int
+	for _, trans := range mp.pending {
+		count += len(trans)
+	}
+
+	return count
+}
+
+// collect returns all the transactions for the account keyed by nonce. The
+// caller must hold the mempool lock.
+func (mp *Mempool) collect(account storage.Account) map[uint]storage.BlockTx {
+	trans := make(map[uint]storage.BlockTx)
+	for _, tx := range mp.pending[account] {
+		trans[tx.Nonce] = tx
+	}
+	for nonce, tx := range mp.queued[account] {
+		trans[nonce] = tx
+	}
+
+	return trans
+}
+
+// arrange splits the transactions for the account into the pending list and
+// the queued set based on the account's last mined nonce. Transactions that
+// have already been mined are dropped. The caller must hold the mempool lock.
+func (mp *Mempool) arrange(account storage.Account, trans map[uint]storage.BlockTx) {
+	delete(mp.pending, account)
+	delete(mp.queued, account)
+
+	nonce := mp.nonceFn(account)
+
+	var pending []storage.BlockTx
+	for next := nonce + 1; ; next++ {
+		tx, exists := trans[next]
+		if !exists {
+			break
+		}
+		pending = append(pending, tx)
+		delete(trans, next)
+	}
+
+	for n := range trans {
+		if n <= nonce {
+			delete(trans, n)
+		}
+	}
+
+	if len(pending) > 0 {
+		mp.pending[account] = pending
+	}
+	if len(trans) > 0 {
+		mp.queued[account] = trans
+	}
+
+	// Drop the arrival times of the transactions no longer in the pool.
+	for n := range mp.arrived[account] {
+		if _, queued := trans[n]; !queued && (n <= nonce || n > nonce+uint(len(pending))) {
+			delete(mp.arrived[account], n)
+		}
+	}
+	if len(mp.arrived[account]) == 0 {
+		delete(mp.arrived, account)
+	}
 }
@@ -0,0 +1,529 @@
+package mempool_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// to receives the value of every transaction.
+const to = storage.Account("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+// sender signs transactions and tracks the nonce last mined for its account.
+type sender struct {
+	privateKey *ecdsa.PrivateKey
+	account    storage.Account
+	nonces     map[storage.Account]uint
+}
+
+// newSender constructs a sender with a new key.
+func newSender(t *testing.T) *sender {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to generate a key: %s", failed, err)
+	}
+
+	snd := sender{
+		privateKey: privateKey,
+		account:    storage.PublicKeyToAccount(privateKey.PublicKey),
+		nonces:     make(map[storage.Account]uint),
+	}
+
+	return &snd
+}
+
+// nonce is the nonce function given to the mempool.
+func (snd *sender) nonce(account storage.Account) uint {
+	return snd.nonces[account]
+}
+
+// tx constructs a signed transaction with the specified nonce and tip.
+func (snd *sender) tx(t *testing.T, nonce uint, tip uint) storage.BlockTx {
+	userTx, err := storage.NewUserTx(1, nonce, to, 10, tip, nil)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to construct the transaction: %s", failed, err)
+	}
+
+	signedTx, err := userTx.Sign(snd.privateKey)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to sign the transaction: %s", failed, err)
+	}
+
+	return storage.NewBlockTx(signedTx, 15)
+}
+
+// newMempool constructs a mempool using the sender's nonces.
+func newMempool(t *testing.T, snd *sender, cfg mempool.Config) *mempool.Mempool {
+	if cfg.SelectStrategy == "" {
+		cfg.SelectStrategy = "tip"
+	}
+	cfg.NonceFunc = snd.nonce
+
+	mp, err := mempool.New(cfg)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to construct the mempool: %s", failed, err)
+	}
+
+	return mp
+}
+
+func TestPending(t *testing.T) {
+	type table struct {
+		name       string
+		insert     []uint
+		mined      uint
+		delete     []uint
+		expCount   int
+		expPending int
+	}
+
+	tt := []table{
+		{name: "nonces have no gap", insert: []uint{1, 2, 3}, expCount: 3, expPending: 3},
+		{name: "nonces have a gap", insert: []uint{1, 3}, expCount: 2, expPending: 1},
+		{name: "gap is filled", insert: []uint{1, 3, 2}, expCount: 3, expPending: 3},
+		{name: "first nonce is missing", insert: []uint{2, 3}, expCount: 2, expPending: 0},
+		{name: "missing nonce is mined", insert: []uint{2, 3}, mined: 1, expCount: 2, expPending: 2},
+		{name: "nonces are mined", insert: []uint{1, 2, 3}, mined: 2, expCount: 1, expPending: 1},
+		{name: "pending nonce is deleted", insert: []uint{1, 2, 3}, delete: []uint{2}, expCount: 2, expPending: 1},
+	}
+
+	t.Log("Given the need to keep pending and queued transactions.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				snd := newSender(t)
+				mp := newMempool(t, snd, mempool.Config{})
+
+				for _, nonce := range test.insert {
+					if _, _, err := mp.Upsert(snd.tx(t, nonce, 10)); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to add nonce %d: %s", failed, testID, nonce, err)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to add the transactions.", success, testID)
+
+				if test.mined > 0 {
+					snd.nonces[snd.account] = test.mined
+					mp.Promote()
+				}
+
+				for _, nonce := range test.delete {
+					if err := mp.Delete(snd.tx(t, nonce, 10)); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to delete nonce %d: %s", failed, testID, nonce, err)
+					}
+				}
+
+				if got := mp.Count(); got != test.expCount {
+					t.Fatalf("\t%s\tTest %d:\tShould have the transactions: got %d, exp %d", failed, testID, got, test.expCount)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the transactions.", success, testID)
+
+				if got := mp.CountPending(); got != test.expPending {
+					t.Fatalf("\t%s\tTest %d:\tShould have the pending transactions: got %d, exp %d", failed, testID, got, test.expPending)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the pending transactions.", success, testID)
+
+				picked := mp.PickBest(-1)
+				if len(picked) != test.expPending {
+					t.Fatalf("\t%s\tTest %d:\tShould only pick the pending transactions: got %d, exp %d", failed, testID, len(picked), test.expPending)
+				}
+				for i, tx := range picked {
+					if exp := snd.nonces[snd.account] + uint(i) + 1; tx.Nonce != exp {
+						t.Fatalf("\t%s\tTest %d:\tShould pick the transactions in nonce order: got %d, exp %d", failed, testID, tx.Nonce, exp)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould only pick the pending transactions.", success, testID)
+			}
+		}
+	}
+}
+
+func TestStaleNonce(t *testing.T) {
+	type table struct {
+		nonce   uint
+		success bool
+	}
+
+	tt := []table{
+		{nonce: 2, success: false},
+		{nonce: 3, success: false},
+		{nonce: 4, success: true},
+		{nonce: 6, success: true},
+	}
+
+	t.Log("Given the need to reject transactions with a nonce that was mined.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen adding nonce %d after nonce 3 was mined.", testID, test.nonce)
+			{
+				snd := newSender(t)
+				snd.nonces[snd.account] = 3
+				mp := newMempool(t, snd, mempool.Config{})
+
+				_, _, err := mp.Upsert(snd.tx(t, test.nonce, 10))
+				if (err == nil) != test.success {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+
+				exp := 0
+				if test.success {
+					exp = 1
+				}
+				if got := mp.Count(); got != exp {
+					t.Fatalf("\t%s\tTest %d:\tShould have the transactions: got %d, exp %d", failed, testID, got, exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the transactions.", success, testID)
+			}
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	type table struct {
+		name      string
+		bump      uint
+		tip       uint
+		newTip    uint
+		expStatus mempool.Status
+		expErr    error
+	}
+
+	tt := []table{
+		{name: "tip isn't raised", bump: 10, tip: 100, newTip: 100, expErr: mempool.ErrUnderpriced},
+		{name: "tip is raised below the bump", bump: 10, tip: 100, newTip: 109, expErr: mempool.ErrUnderpriced},
+		{name: "tip is raised by the bump", bump: 10, tip: 100, newTip: 110, expStatus: mempool.StatusReplaced},
+		{name: "small tip isn't raised", bump: 10, tip: 5, newTip: 5, expErr: mempool.ErrUnderpriced},
+		{name: "small tip is raised by 1", bump: 10, tip: 5, newTip: 6, expStatus: mempool.StatusReplaced},
+		{name: "tip is kept with no bump", bump: 0, tip: 100, newTip: 100, expStatus: mempool.StatusReplaced},
+	}
+
+	t.Log("Given the need to replace a transaction with the same nonce.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				snd := newSender(t)
+				mp := newMempool(t, snd, mempool.Config{ReplaceTipBump: test.bump})
+
+				if _, _, err := mp.Upsert(snd.tx(t, 1, test.tip)); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to add the transaction: %s", failed, testID, err)
+				}
+
+				status, _, err := mp.Upsert(snd.tx(t, 1, test.newTip))
+				if !errors.Is(err, test.expErr) || status != test.expStatus {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: got %q %v, exp %q %v", failed, testID, status, err, test.expStatus, test.expErr)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+
+				exp := test.tip
+				if test.expErr == nil {
+					exp = test.newTip
+				}
+				trans := mp.ByAccount(snd.account)
+				if len(trans) != 1 || trans[0].Tip != exp {
+					t.Fatalf("\t%s\tTest %d:\tShould keep one transaction with tip %d: got %v", failed, testID, exp, trans)
+				}
+				t.Logf("\t%s\tTest %d:\tShould keep one transaction with tip %d.", success, testID, exp)
+			}
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	type entry struct {
+		sender int
+		nonce  uint
+		tip    uint
+	}
+
+	type table struct {
+		name       string
+		maxSize    int
+		maxPerAct  int
+		existing   []entry
+		add        entry
+		expErr     error
+		expEvicted *entry
+	}
+
+	tt := []table{
+		{
+			name:     "tip is lower than every transaction",
+			maxSize:  2,
+			existing: []entry{{0, 1, 10}, {1, 1, 20}},
+			add:      entry{2, 1, 5},
+			expErr:   mempool.ErrFull,
+		},
+		{
+			name:     "tip is equal to the lowest tip",
+			maxSize:  2,
+			existing: []entry{{0, 1, 10}, {1, 1, 20}},
+			add:      entry{2, 1, 10},
+			expErr:   mempool.ErrFull,
+		},
+		{
+			name:       "tip is higher than the lowest tip",
+			maxSize:    2,
+			existing:   []entry{{0, 1, 10}, {1, 1, 20}},
+			add:        entry{2, 1, 15},
+			expEvicted: &entry{0, 1, 10},
+		},
+		{
+			name:       "lowest tip is in the middle of a pending list",
+			maxSize:    3,
+			existing:   []entry{{0, 1, 1}, {0, 2, 50}, {1, 1, 20}},
+			add:        entry{2, 1, 30},
+			expEvicted: &entry{1, 1, 20},
+		},
+		{
+			name:       "lowest tip is queued",
+			maxSize:    3,
+			existing:   []entry{{0, 1, 40}, {0, 3, 5}, {1, 1, 20}},
+			add:        entry{2, 1, 30},
+			expEvicted: &entry{0, 3, 5},
+		},
+		{
+			name:      "account is at its limit",
+			maxPerAct: 2,
+			existing:  []entry{{0, 1, 10}, {0, 2, 10}},
+			add:       entry{0, 3, 100},
+			expErr:    mempool.ErrAccountLimit,
+		},
+	}
+
+	t.Log("Given the need to bound the size of the mempool.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				senders := []*sender{newSender(t), newSender(t), newSender(t)}
+				nonces := func(account storage.Account) uint { return 0 }
+
+				mp, err := mempool.New(mempool.Config{
+					SelectStrategy: "tip",
+					MaxSize:        test.maxSize,
+					MaxPerAccount:  test.maxPerAct,
+					NonceFunc:      nonces,
+				})
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to construct the mempool: %s", failed, testID, err)
+				}
+
+				for _, e := range test.existing {
+					if _, _, err := mp.Upsert(senders[e.sender].tx(t, e.nonce, e.tip)); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to add the transactions: %s", failed, testID, err)
+					}
+				}
+
+				_, _, err = mp.Upsert(senders[test.add.sender].tx(t, test.add.nonce, test.add.tip))
+				if !errors.Is(err, test.expErr) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected error: got %v, exp %v", failed, testID, err, test.expErr)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected error.", success, testID)
+
+				exp := len(test.existing)
+				if test.expErr == nil {
+					exp = test.maxSize
+				}
+				if got := mp.Count(); got != exp {
+					t.Fatalf("\t%s\tTest %d:\tShould have the transactions: got %d, exp %d", failed, testID, got, exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the transactions.", success, testID)
+
+				if ev := test.expEvicted; ev != nil {
+					for _, tx := range mp.ByAccount(senders[ev.sender].account) {
+						if tx.Nonce == ev.nonce {
+							t.Fatalf("\t%s\tTest %d:\tShould evict the transaction with the lowest tip: nonce %d tip %d still in the pool", failed, testID, ev.nonce, ev.tip)
+						}
+					}
+					t.Logf("\t%s\tTest %d:\tShould evict the transaction with the lowest tip.", success, testID)
+				}
+			}
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	type table struct {
+		name       string
+		ttl        time.Duration
+		timeStamp  time.Duration // Offset from now for the transaction timestamp.
+		arrived    time.Duration // Offset from now the transaction arrived, zero for now.
+		wait       time.Duration
+		expErr     error
+		expExpired int
+	}
+
+	tt := []table{
+		{name: "peer timestamp is older than the TTL", ttl: time.Hour, timeStamp: -2 * time.Hour},
+		{name: "timestamp is in the future", ttl: time.Hour, timeStamp: time.Hour, expErr: mempool.ErrFutureTimestamp},
+		{name: "timestamp is within the clock drift", ttl: time.Hour, timeStamp: time.Minute},
+		{name: "journal arrival is older than the TTL", ttl: time.Hour, arrived: -2 * time.Hour, expErr: mempool.ErrExpired},
+		{name: "journal arrival is within the TTL", ttl: time.Hour, arrived: -30 * time.Minute},
+		{name: "transaction stays longer than the TTL", ttl: time.Second, wait: 1100 * time.Millisecond, expExpired: 1},
+		{name: "TTL is not set", ttl: 0, arrived: -24 * time.Hour},
+	}
+
+	t.Log("Given the need to expire transactions that stay in the mempool too long.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				snd := newSender(t)
+				mp := newMempool(t, snd, mempool.Config{TTL: test.ttl})
+
+				tx := snd.tx(t, 1, 10)
+				tx.TimeStamp = uint64(time.Now().Add(test.timeStamp).Unix())
+
+				var err error
+				switch test.arrived {
+				case 0:
+					_, _, err = mp.Upsert(tx)
+				default:
+					_, _, err = mp.UpsertJournal(mempool.JournalTx{BlockTx: tx, Arrived: time.Now().Add(test.arrived).Unix()})
+				}
+				if !errors.Is(err, test.expErr) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected error: got %v, exp %v", failed, testID, err, test.expErr)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected error.", success, testID)
+
+				if test.expErr != nil {
+					continue
+				}
+
+				time.Sleep(test.wait)
+
+				if got := mp.Expire(); got != test.expExpired {
+					t.Fatalf("\t%s\tTest %d:\tShould expire the transactions: got %d, exp %d", failed, testID, got, test.expExpired)
+				}
+				if got := mp.Count(); got != 1-test.expExpired {
+					t.Fatalf("\t%s\tTest %d:\tShould keep the other transactions: got %d, exp %d", failed, testID, got, 1-test.expExpired)
+				}
+				t.Logf("\t%s\tTest %d:\tShould expire the transactions.", success, testID)
+			}
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.mempool")
+
+	t.Log("Given the need to keep the mempool when the node restarts.")
+	{
+		t.Logf("\tTest 0:\tWhen replaying the journal into a new mempool.")
+		{
+			snd := newSender(t)
+			mp := newMempool(t, snd, mempool.Config{ReplaceTipBump: 10, JournalPath: path})
+
+			if err := mp.Rotate(); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to open the journal: %s", failed, err)
+			}
+
+			for _, tx := range []storage.BlockTx{snd.tx(t, 1, 10), snd.tx(t, 2, 10), snd.tx(t, 3, 10), snd.tx(t, 2, 20)} {
+				if _, _, err := mp.Upsert(tx); err != nil {
+					t.Fatalf("\t%s\tTest 0:\tShould be able to add the transactions: %s", failed, err)
+				}
+			}
+
+			if err := mp.Close(); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to close the journal: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to write the journal.", success)
+
+			jtxs, err := mempool.ReadJournal(path)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to read the journal: %s", failed, err)
+			}
+			if len(jtxs) != 4 {
+				t.Fatalf("\t%s\tTest 0:\tShould read every transaction added: got %d, exp %d", failed, len(jtxs), 4)
+			}
+			for _, jtx := range jtxs {
+				if since := time.Since(time.Unix(jtx.Arrived, 0)); since < 0 || since > time.Minute {
+					t.Fatalf("\t%s\tTest 0:\tShould keep the arrival time: got %d", failed, jtx.Arrived)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould read every transaction added.", success)
+
+			replay := newMempool(t, snd, mempool.Config{ReplaceTipBump: 10, JournalPath: path})
+			for _, jtx := range jtxs {
+				if _, _, err := replay.UpsertJournal(jtx); err != nil {
+					t.Fatalf("\t%s\tTest 0:\tShould be able to replay the transactions: %s", failed, err)
+				}
+			}
+
+			exp := mp.ByAccount(snd.account)
+			got := replay.ByAccount(snd.account)
+			if len(got) != len(exp) {
+				t.Fatalf("\t%s\tTest 0:\tShould get the same transactions back: got %d, exp %d", failed, len(got), len(exp))
+			}
+			for i := range exp {
+				if got[i].Nonce != exp[i].Nonce || got[i].Tip != exp[i].Tip || got[i].SignatureString() != exp[i].SignatureString() {
+					t.Fatalf("\t%s\tTest 0:\tShould get the same transactions back: got %v, exp %v", failed, got[i], exp[i])
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the same transactions back.", success)
+
+			if err := replay.Rotate(); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to rotate the journal: %s", failed, err)
+			}
+			if err := replay.Close(); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to close the journal: %s", failed, err)
+			}
+
+			rotated, err := mempool.ReadJournal(path)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to read the rotated journal: %s", failed, err)
+			}
+			if len(rotated) != 3 {
+				t.Fatalf("\t%s\tTest 0:\tShould only keep the transactions in the pool: got %d, exp %d", failed, len(rotated), 3)
+			}
+			arrived := make(map[int64]bool)
+			for _, jtx := range jtxs {
+				arrived[jtx.Arrived] = true
+			}
+			for _, jtx := range rotated {
+				if !arrived[jtx.Arrived] {
+					t.Fatalf("\t%s\tTest 0:\tShould keep the arrival time when rotated: got %d", failed, jtx.Arrived)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould only keep the transactions in the pool.", success)
+		}
+
+		t.Logf("\tTest 1:\tWhen the journal ends with a partial transaction.")
+		{
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould be able to open the journal: %s", failed, err)
+			}
+			if _, err := file.WriteString(`{"chain_id":1,"nonce":`); err != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould be able to write a partial transaction: %s", failed, err)
+			}
+			file.Close()
+
+			jtxs, err := mempool.ReadJournal(path)
+			if err == nil {
+				t.Fatalf("\t%s\tTest 1:\tShould get an error for the partial transaction.", failed)
+			}
+			t.Logf("\t%s\tTest 1:\tShould get an error for the partial transaction.", success)
+
+			if len(jtxs) != 3 {
+				t.Fatalf("\t%s\tTest 1:\tShould read the transactions before it: got %d, exp %d", failed, len(jtxs), 3)
+			}
+			t.Logf("\t%s\tTest 1:\tShould read the transactions before it.", success)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package selector
+
+import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// fifoSelect returns transactions in the order they were submitted based on
+// the transaction timestamp while respecting the nonce for each account.
+var fifoSelect = func(transactions map[storage.Account][]storage.BlockTx, howMany int) []storage.BlockTx {
+	better := func(a, b storage.BlockTx) bool {
+		return a.TimeStamp < b.TimeStamp
+	}
+
+	return pick(transactions, howMany, better)
+}
@@ -0,0 +1,105 @@
+// Package selector provides different transaction selecting algorithms.
+package selector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// List of different select strategies.
+const (
+	StrategyTip  = "tip"
+	StrategyFIFO = "fifo"
+)
+
+// Map of different select strategies with functions.
+var strategies = map[string]Func{
+	StrategyTip:  tipSelect,
+	StrategyFIFO: fifoSelect,
+}
+
+// Func defines a function that takes a mempool of transactions grouped by
+// account and selects howMany of them in an order based on the function's
+// strategy. All selector functions MUST respect nonce ordering within an
+// account. Receiving -1 for howMany must return all the transactions in the
+// strategy ordering.
+type Func func(transactions map[storage.Account][]storage.BlockTx, howMany int) []storage.BlockTx
+
+// Retrieve returns the specified select strategy function. The name of
+// the strategy is not case sensitive.
+func Retrieve(strategy string) (Func, error) {
+	fn, exists := strategies[strings.ToLower(strategy)]
+	if !exists {
+		return nil, fmt.Errorf("strategy %q does not exist", strategy)
+	}
+
+	return fn, nil
+}
+
+// =============================================================================
+
+// pick selects transactions by repeatedly taking the best transaction at the
+// front of each account's list, as decided by the better function. Sorting
+// each account's list by nonce first means the transactions for an account
+// are always selected in nonce order.
+func pick(transactions map[storage.Account][]storage.BlockTx, howMany int, better func(a, b storage.BlockTx) bool) []storage.BlockTx {
+	accounts := make([]storage.Account, 0, len(transactions))
+	queues := make(map[storage.Account][]storage.BlockTx, len(transactions))
+	total := 0
+	for account, trans := range transactions {
+		queue := make([]storage.BlockTx, len(trans))
+		copy(queue, trans)
+		sort.Sort(byNonce(queue))
+
+		accounts = append(accounts, account)
+		queues[account] = queue
+		total += len(queue)
+	}
+
+	// Order the accounts so ties are broken the same way every time.
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+
+	if howMany < 0 || howMany > total {
+		howMany = total
+	}
+
+	final := make([]storage.BlockTx, 0, howMany)
+	for len(final) < howMany {
+		var best storage.Account
+		for _, account := range accounts {
+			if len(queues[account]) == 0 {
+				continue
+			}
+			if best == "" || better(queues[account][0], queues[best][0]) {
+				best = account
+			}
+		}
+
+		final = append(final, queues[best][0])
+		queues[best] = queues[best][1:]
+	}
+
+	return final
+}
+
+// byNonce provides sorting support by the transaction nonce value.
+type byNonce []storage.BlockTx
+
+// Len returns the number of transactions in the list.
+func (bn byNonce) Len() int {
+	return len(bn)
+}
+
+// Less helps to sort the list by nonce in ascending order to keep the
+// transactions in the right order of processing.
+func (bn byNonce) Less(i, j int) bool {
+	return bn[i].Nonce < bn[j].Nonce
+}
+
+// Swap moves transactions in the order of the nonce value.
+func (bn byNonce) Swap(i, j int) {
+	bn[i], bn[j] = bn[j], bn[i]
+}
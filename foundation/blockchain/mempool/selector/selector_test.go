@@ -0,0 +1,155 @@
+package selector_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// Accounts the transactions are grouped by.
+const (
+	accountA = storage.Account("0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9")
+	accountB = storage.Account("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+)
+
+// newTx constructs a transaction with the values the selectors look at. The
+// name is kept in the data to tell the selected transactions apart.
+func newTx(name string, nonce uint, tip uint, timeStamp uint64) storage.BlockTx {
+	return storage.BlockTx{
+		SignedTx: storage.SignedTx{
+			UserTx: storage.UserTx{Nonce: nonce, Tip: tip, Data: []byte(name)},
+		},
+		TimeStamp: timeStamp,
+	}
+}
+
+func TestSelect(t *testing.T) {
+	type table struct {
+		name     string
+		strategy string
+		trans    map[storage.Account][]storage.BlockTx
+		howMany  int
+		exp      []string
+	}
+
+	tt := []table{
+		{
+			name:     "tip picks the best tip",
+			strategy: selector.StrategyTip,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A1", 1, 5, 10), newTx("A2", 2, 50, 11)},
+				accountB: {newTx("B1", 1, 10, 12)},
+			},
+			howMany: -1,
+			exp:     []string{"B1", "A1", "A2"},
+		},
+		{
+			name:     "tip breaks a tie with the timestamp",
+			strategy: selector.StrategyTip,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A1", 1, 10, 20)},
+				accountB: {newTx("B1", 1, 10, 15)},
+			},
+			howMany: -1,
+			exp:     []string{"B1", "A1"},
+		},
+		{
+			name:     "tip keeps nonce order within an account",
+			strategy: selector.StrategyTip,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A2", 2, 100, 10), newTx("A1", 1, 1, 11)},
+				accountB: {newTx("B1", 1, 50, 12)},
+			},
+			howMany: -1,
+			exp:     []string{"B1", "A1", "A2"},
+		},
+		{
+			name:     "fifo picks the oldest timestamp",
+			strategy: selector.StrategyFIFO,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A1", 1, 1, 20), newTx("A2", 2, 1, 40)},
+				accountB: {newTx("B1", 1, 100, 30)},
+			},
+			howMany: -1,
+			exp:     []string{"A1", "B1", "A2"},
+		},
+		{
+			name:     "fifo keeps nonce order within an account",
+			strategy: selector.StrategyFIFO,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A2", 2, 1, 10), newTx("A1", 1, 1, 50)},
+				accountB: {newTx("B1", 1, 1, 30)},
+			},
+			howMany: -1,
+			exp:     []string{"B1", "A1", "A2"},
+		},
+		{
+			name:     "selection is limited",
+			strategy: selector.StrategyTip,
+			trans: map[storage.Account][]storage.BlockTx{
+				accountA: {newTx("A1", 1, 5, 10), newTx("A2", 2, 50, 11)},
+				accountB: {newTx("B1", 1, 10, 12)},
+			},
+			howMany: 2,
+			exp:     []string{"B1", "A1"},
+		},
+	}
+
+	t.Log("Given the need to select transactions from the mempool.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen %s.", testID, test.name)
+			{
+				selectFn, err := selector.Retrieve(test.strategy)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the strategy: %s", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to retrieve the strategy.", success, testID)
+
+				var got []string
+				for _, tx := range selectFn(test.trans, test.howMany) {
+					got = append(got, string(tx.Data))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(test.exp) {
+					t.Fatalf("\t%s\tTest %d:\tShould select the transactions in order: got %v, exp %v", failed, testID, got, test.exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould select the transactions in order.", success, testID)
+			}
+		}
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	type table struct {
+		strategy string
+		success  bool
+	}
+
+	tt := []table{
+		{strategy: "tip", success: true},
+		{strategy: "FIFO", success: true},
+		{strategy: "random", success: false},
+	}
+
+	t.Log("Given the need to choose a select strategy by name.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen retrieving %q.", testID, test.strategy)
+			{
+				_, err := selector.Retrieve(test.strategy)
+				if (err == nil) != test.success {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+			}
+		}
+	}
+}
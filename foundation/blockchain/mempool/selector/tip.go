@@ -0,0 +1,19 @@
+package selector
+
+import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// tipSelect returns transactions with the best tip while respecting the nonce
+// for each account. Transactions with the same tip are selected in the order
+// they were submitted.
+var tipSelect = func(transactions map[storage.Account][]storage.BlockTx, howMany int) []storage.BlockTx {
+	better := func(a, b storage.BlockTx) bool {
+		if a.Tip != b.Tip {
+			return a.Tip > b.Tip
+		}
+		return a.TimeStamp < b.TimeStamp
+	}
+
+	return pick(transactions, howMany, better)
+}
@@ -0,0 +1,129 @@
+// Package merkle provides support for building a merkle tree over a set of
+// hashes and for producing and verifying proofs that a hash is included in
+// the tree.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// Prefixes are mixed into the hashes so a leaf can never be passed off as
+// an interior node of the tree and the other way around.
+const (
+	leafPrefix     = 0x00
+	interiorPrefix = 0x01
+)
+
+// Step represents a sibling hash needed to rebuild the root of the tree
+// from a leaf.
+type Step struct {
+	Hash string `json:"hash"` // Hex-encoded hash of the sibling.
+	Left bool   `json:"left"` // True if the sibling is on the left.
+}
+
+// Root calculates the root of the merkle tree built from the leaves. The
+// root of a tree without leaves is all zeros.
+func Root(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return make([]byte, sha256.Size)
+	}
+
+	level := hashLeaves(leaves)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+
+	return level[0]
+}
+
+// Proof returns the steps needed to rebuild the root of the merkle tree from
+// the leaf at the specified index.
+func Proof(leaves [][]byte, index int) ([]Step, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("leaf index out of range")
+	}
+
+	var proof []Step
+
+	level := hashLeaves(leaves)
+	for len(level) > 1 {
+		switch {
+		case index%2 == 1:
+			proof = append(proof, Step{Hash: hex.EncodeToString(level[index-1]), Left: true})
+		case index+1 < len(level):
+			proof = append(proof, Step{Hash: hex.EncodeToString(level[index+1]), Left: false})
+		}
+
+		level = nextLevel(level)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// Verify checks the proof rebuilds the specified root from the leaf.
+func Verify(leaf []byte, proof []Step, root []byte) bool {
+	hash := hashLeaf(leaf)
+
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+
+		if step.Left {
+			hash = hashInterior(sibling, hash)
+			continue
+		}
+		hash = hashInterior(hash, sibling)
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// =============================================================================
+
+// hashLeaves hashes each leaf to produce the bottom level of the tree.
+func hashLeaves(leaves [][]byte) [][]byte {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = hashLeaf(leaf)
+	}
+
+	return level
+}
+
+// nextLevel hashes pairs of nodes to produce the level above. A node without
+// a pair is promoted to the next level as is.
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, hashInterior(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+// hashLeaf returns the hash for a leaf of the tree.
+func hashLeaf(leaf []byte) []byte {
+	hash := sha256.Sum256(append([]byte{leafPrefix}, leaf...))
+	return hash[:]
+}
+
+// hashInterior returns the hash for a node of the tree from its children.
+func hashInterior(left []byte, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, interiorPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
@@ -0,0 +1,109 @@
+package merkle_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// newLeaves returns a leaf for each of the specified names.
+func newLeaves(names ...string) [][]byte {
+	leaves := make([][]byte, len(names))
+	for i, name := range names {
+		leaves[i] = []byte(name)
+	}
+
+	return leaves
+}
+
+func TestRoot(t *testing.T) {
+	type table struct {
+		name   string
+		leaves [][]byte
+		exp    string
+	}
+
+	tt := []table{
+		{name: "no leaves", leaves: newLeaves(), exp: strings.Repeat("00", 32)},
+		{name: "one leaf", leaves: newLeaves("a"), exp: "022a6979e6dab7aa5ae4c3e5e45f7e977112a7e63593820dbec1ec738a24f93c"},
+		{name: "two leaves", leaves: newLeaves("a", "b"), exp: "b137985ff484fb600db93107c77b0365c80d78f5b429ded0fd97361d077999eb"},
+		{name: "three leaves", leaves: newLeaves("a", "b", "c"), exp: "36642e73c2540ab121e3a6bf9545b0a24982cd830eb13d3cd19de3ce6c021ec1"},
+		{name: "four leaves", leaves: newLeaves("a", "b", "c", "d"), exp: "33376a3bd63e9993708a84ddfe6c28ae58b83505dd1fed711bd924ec5a6239f0"},
+		{name: "five leaves", leaves: newLeaves("a", "b", "c", "d", "e"), exp: "fe14a5426fbd70c0fa73f52342afed0da0bd23c4838662ccf6b88a3070ead97b"},
+	}
+
+	t.Log("Given the need to calculate the root of a merkle tree.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the tree has %s.", testID, test.name)
+			{
+				root := hex.EncodeToString(merkle.Root(test.leaves))
+				if root != test.exp {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected root: got %s, exp %s", failed, testID, root, test.exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected root.", success, testID)
+			}
+		}
+	}
+}
+
+func TestProof(t *testing.T) {
+	t.Log("Given the need to prove a leaf is included in a merkle tree.")
+	{
+		for testID, count := range []int{1, 2, 3, 4, 5, 7} {
+			t.Logf("\tTest %d:\tWhen the tree has %d leaves.", testID, count)
+			{
+				leaves := newLeaves("a", "b", "c", "d", "e", "f", "g")[:count]
+				root := merkle.Root(leaves)
+
+				for index, leaf := range leaves {
+					proof, err := merkle.Proof(leaves, index)
+					if err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to get the proof for leaf %d: %s", failed, testID, index, err)
+					}
+
+					if !merkle.Verify(leaf, proof, root) {
+						t.Fatalf("\t%s\tTest %d:\tShould verify the proof for leaf %d.", failed, testID, index)
+					}
+
+					if merkle.Verify([]byte("x"), proof, root) {
+						t.Fatalf("\t%s\tTest %d:\tShould not verify a tampered leaf %d.", failed, testID, index)
+					}
+
+					for i := range proof {
+						tampered := make([]merkle.Step, len(proof))
+						copy(tampered, proof)
+						tampered[i].Hash = strings.Repeat("00", 32)
+
+						if merkle.Verify(leaf, tampered, root) {
+							t.Fatalf("\t%s\tTest %d:\tShould not verify leaf %d with a tampered sibling %d.", failed, testID, index, i)
+						}
+
+						tampered[i] = proof[i]
+						tampered[i].Left = !tampered[i].Left
+						if merkle.Verify(leaf, tampered, root) {
+							t.Fatalf("\t%s\tTest %d:\tShould not verify leaf %d with sibling %d on the wrong side.", failed, testID, index, i)
+						}
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould verify the proof for every leaf.", success, testID)
+				t.Logf("\t%s\tTest %d:\tShould not verify a tampered leaf or sibling.", success, testID)
+
+				for _, index := range []int{-1, count} {
+					if _, err := merkle.Proof(leaves, index); err == nil {
+						t.Fatalf("\t%s\tTest %d:\tShould not get a proof for leaf %d.", failed, testID, index)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould not get a proof for a leaf out of range.", success, testID)
+			}
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package peer
 
-import "sync"
+import (
+	"math/big"
+	"sync"
+)
 
 // Peer represents information about a Node in the network.
 type Peer struct {
@@ -24,9 +27,10 @@ func (p Peer) Match(host string) bool {
 // PeerStatus represents information about the status
 // of any given peer.
 type PeerStatus struct {
-	LatestBlockHash   string `json:"latest_block_hash"`
-	LatestBlockNumber uint64 `json:"latest_block_number"`
-	KnownPeers        []Peer `json:"known_peers"`
+	LatestBlockHash   string   `json:"latest_block_hash"`
+	LatestBlockNumber uint64   `json:"latest_block_number"`
+	LatestBlockWork   *big.Int `json:"latest_block_work"` // Total work performed on the chain up to the latest block.
+	KnownPeers        []Peer   `json:"known_peers"`
 }
 
 // =============================================================================
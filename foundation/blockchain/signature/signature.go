@@ -1,5 +1,10 @@
 // Package signature provides helper functions for handling the blockchain
 // signature needs.
+//
+// Values are serialized with RLP before they are hashed or signed, so the
+// bytes don't depend on Go struct tags or how a JSON library happens to
+// encode a value. A type controls its serialization by implementing the
+// rlp.Encoder interface.
 package signature
 
 import (
@@ -7,12 +12,12 @@ import (
 	"crypto/elliptic"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // ZeroHash represents a hash code of zeros.
@@ -27,13 +32,24 @@ const ardanID = 29
 
 // Hash returns a unique string for the value.
 func Hash(value interface{}) string {
-	data, err := json.Marshal(value)
-	if err != nil {
+	hash := HashBytes(value)
+	if hash == nil {
 		return ZeroHash
 	}
 
+	return hex.EncodeToString(hash)
+}
+
+// HashBytes returns a unique 32 byte hash for the value. If the value can't
+// be serialized, nil is returned.
+func HashBytes(value interface{}) []byte {
+	data, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return nil
+	}
+
 	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+	return hash[:]
 }
 
 // Sign uses the specified private key to sign the user transaction.
@@ -60,22 +76,30 @@ func Sign(value interface{}, privateKey *ecdsa.PrivateKey) (v, r, s *big.Int, er
 // VerifySignature verifies the signature conforms to our standards and
 // is associated with the data claimed to be signed.
 func VerifySignature(value interface{}, v, r, s *big.Int) error {
+	_, err := FromAddress(value, v, r, s)
+	return err
+}
+
+// FromAddress verifies the signature conforms to our standards and is
+// associated with the data claimed to be signed, and extracts the address
+// for the account that signed the transaction.
+func FromAddress(value interface{}, v, r, s *big.Int) (string, error) {
 
 	// Check the recovery id is either 0 or 1.
 	uintV := v.Uint64() - ardanID
 	if uintV != 0 && uintV != 1 {
-		return errors.New("invalid recovery id")
+		return "", errors.New("invalid recovery id")
 	}
 
 	// Check the signature values are valid.
 	if !crypto.ValidateSignatureValues(byte(uintV), r, s, false) {
-		return errors.New("invalid signature values")
+		return "", errors.New("invalid signature values")
 	}
 
 	// Prepare the transaction for recovery and validation.
 	tran, err := stamp(value)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Convert the [R|S|V] format into the original 65 bytes.
@@ -84,43 +108,15 @@ func VerifySignature(value interface{}, v, r, s *big.Int) error {
 	// Capture the uncompressed public key associated with this signature.
 	sigPublicKey, err := crypto.Ecrecover(tran, sig)
 	if err != nil {
-		return fmt.Errorf("ecrecover, %w", err)
+		return "", fmt.Errorf("ecrecover, %w", err)
 	}
 
 	// Check that the given public key created the signature over the data.
+	// This also catches conversion issues between [R|S|V] and []bytes since
+	// leading 0's are truncated by the big package.
 	rs := sig[:crypto.RecoveryIDOffset]
 	if !crypto.VerifySignature(sigPublicKey, tran, rs) {
-		return errors.New("invalid signature")
-	}
-
-	return nil
-}
-
-// FromAddress extracts the address for the account that signed the transaction.
-func FromAddress(value interface{}, v, r, s *big.Int) (string, error) {
-
-	// Prepare the transaction for public key extraction.
-	tran, err := stamp(value)
-	if err != nil {
-		return "", err
-	}
-
-	// Convert the [R|S|V] format into the original 65 bytes.
-	sig := toSignatureBytes(v, r, s)
-
-	// Validate the signature since there can be conversion issues
-	// between [R|S|V] to []bytes. Leading 0's are truncated by big package.
-	var sigPublicKey []byte
-	{
-		sigPublicKey, err = crypto.Ecrecover(tran, sig)
-		if err != nil {
-			return "", err
-		}
-
-		rs := sig[:crypto.RecoveryIDOffset]
-		if !crypto.VerifySignature(sigPublicKey, tran, rs) {
-			return "", errors.New("invalid signature")
-		}
+		return "", errors.New("invalid signature")
 	}
 
 	// Capture the public key associated with this signature.
@@ -142,8 +138,8 @@ func SignatureString(v, r, s *big.Int) string {
 // transaction with the Ardan stamp embedded into the final hash.
 func stamp(value interface{}) ([]byte, error) {
 
-	// Marshal the data.
-	data, err := json.Marshal(value)
+	// Serialize the data.
+	data, err := rlp.EncodeToBytes(value)
 	if err != nil {
 		return nil, err
 	}
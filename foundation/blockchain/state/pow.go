@@ -3,10 +3,14 @@ package state
 import (
 	"context"
 	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"math"
 	"math/big"
+	"math/bits"
 	"time"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 )
 
@@ -43,7 +47,7 @@ func performPOW(ctx context.Context, difficulty int, b storage.Block, ev EventHa
 		}
 
 		// Hash the block and check if we have solved the puzzle.
-		hash := b.Hash()
+		hash := b.HashBytes()
 		if !isHashSolved(difficulty, hash) {
 			b.Header.Nonce++
 			continue
@@ -55,26 +59,88 @@ func performPOW(ctx context.Context, difficulty int, b storage.Block, ev EventHa
 			return storage.BlockFS{}, time.Since(t), ctx.Err()
 		}
 
-		ev("worker: runMiningOperation: MINING: POW: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.ParentHash, hash)
+		ev("worker: runMiningOperation: MINING: POW: SOLVED: prevBlk[%s]: newBlk[%x]", b.Header.ParentHash, hash)
 		ev("worker: runMiningOperation: MINING: POW: attempts[%d]", attempts)
 
 		// We found a solution to the POW.
 		bfs := storage.BlockFS{
-			Hash:  hash,
+			Hash:  hex.EncodeToString(hash),
 			Block: b,
 		}
 		return bfs, time.Since(t), nil
 	}
 }
 
-// isHashSolved checks the hash to make sure it complies with
-// the POW rules. We need to match a difficulty number of 0's.
-func isHashSolved(difficulty int, hash string) bool {
-	const match = "00000000000000000"
+// maxDifficulty represents the largest number of leading zero bits a hash
+// solution can be required to have.
+const maxDifficulty = 256
 
-	if len(hash) != 64 {
+// isHashSolved checks the hash to make sure it complies with the POW rules.
+// Read as a 256 bit number, the hash must have a difficulty number of leading
+// zero bits, which means it must be below a target of 2^(256-difficulty).
+func isHashSolved(difficulty int, hash []byte) bool {
+	if len(hash) != 32 || difficulty < 0 || difficulty > maxDifficulty {
 		return false
 	}
 
-	return hash[:difficulty] == match[:difficulty]
+	var zeros int
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 || zeros >= difficulty {
+			break
+		}
+	}
+
+	return zeros >= difficulty
+}
+
+// blockWork returns the expected number of hashes needed to solve a block
+// with the specified difficulty. Each leading zero bit required in the hash
+// doubles the work.
+func blockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// retarget returns the difficulty required for a block that builds on the
+// specified parent. The difficulty is adjusted every retarget interval based
+// on how long it took to mine the blocks in the previous interval compared to
+// the target block time. An error is returned when the block tree doesn't
+// hold the entire previous interval, which only happens for a branch that
+// forks off near the root of the tree.
+func retarget(gen genesis.Genesis, parent *node) (int, error) {
+
+	// The first blocks use the difficulty from the genesis file.
+	if parent.block.Header.Number == 0 {
+		return gen.Difficulty, nil
+	}
+
+	difficulty := parent.block.Header.Difficulty
+	interval := gen.RetargetInterval
+	number := parent.block.Header.Number + 1
+
+	// Only adjust the difficulty at the start of a new interval.
+	if interval < 2 || gen.TargetBlockTime == 0 || number <= interval || (number-1)%interval != 0 {
+		return difficulty, nil
+	}
+
+	// Find the first block of the previous interval.
+	first := parent
+	for i := uint64(1); i < interval; i++ {
+		if first.parent == nil {
+			return 0, fmt.Errorf("block tree doesn't hold the retarget interval for block %d", number)
+		}
+		first = first.parent
+	}
+
+	actual := int64(parent.block.Header.TimeStamp) - int64(first.block.Header.TimeStamp)
+	expected := int64((interval - 1) * gen.TargetBlockTime)
+
+	switch {
+	case actual < expected/2 && difficulty < maxDifficulty:
+		difficulty++
+	case actual > expected*2 && difficulty > 1:
+		difficulty--
+	}
+
+	return difficulty, nil
 }
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// newNodes links a node for each timestamp, numbering the blocks from the
+// specified number, and returns the last node.
+func newNodes(number uint64, difficulty int, timeStamps ...uint64) *node {
+	var nd *node
+	for i, timeStamp := range timeStamps {
+		block := storage.Block{
+			Header: storage.BlockHeader{
+				Number:     number + uint64(i),
+				Difficulty: difficulty,
+				TimeStamp:  timeStamp,
+			},
+		}
+		nd = &node{block: block, parent: nd}
+	}
+
+	return nd
+}
+
+func TestRetarget(t *testing.T) {
+	type table struct {
+		name    string
+		parent  *node
+		exp     int
+		success bool
+	}
+
+	gen := genesis.Genesis{
+		Difficulty:       5,
+		TargetBlockTime:  10,
+		RetargetInterval: 4,
+	}
+
+	tt := []table{
+		{name: "block follows genesis", parent: newNodes(0, 9, 0), exp: 5, success: true},
+		{name: "block doesn't start an interval", parent: newNodes(0, 9, 0, 100, 101, 102), exp: 9, success: true},
+		{name: "interval was mined on target", parent: newNodes(0, 9, 0, 100, 110, 120, 130), exp: 9, success: true},
+		{name: "interval was mined too fast", parent: newNodes(0, 9, 0, 100, 101, 102, 103), exp: 10, success: true},
+		{name: "interval was mined too slow", parent: newNodes(0, 9, 0, 100, 150, 200, 250), exp: 8, success: true},
+		{name: "interval was mined too fast at the max difficulty", parent: newNodes(0, maxDifficulty, 0, 100, 101, 102, 103), exp: maxDifficulty, success: true},
+		{name: "interval was mined too slow at the min difficulty", parent: newNodes(0, 1, 0, 100, 150, 200, 250), exp: 1, success: true},
+		{name: "tree doesn't hold the interval", parent: newNodes(3, 9, 102, 103), success: false},
+	}
+
+	t.Log("Given the need to adjust the difficulty to the block time.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				difficulty, err := retarget(gen, test.parent)
+				if (err == nil) != test.success {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected error: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected error.", success, testID)
+
+				if difficulty != test.exp {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected difficulty: got %d, exp %d", failed, testID, difficulty, test.exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected difficulty.", success, testID)
+			}
+		}
+	}
+}
+
+func TestIsHashSolved(t *testing.T) {
+	type table struct {
+		name       string
+		difficulty int
+		hash       []byte
+		exp        bool
+	}
+
+	// hash returns a 32 byte hash starting with the specified bytes and
+	// filled with 0xff after them.
+	hash := func(prefix ...byte) []byte {
+		h := make([]byte, 32)
+		for i := range h {
+			h[i] = 0xff
+		}
+		copy(h, prefix)
+		return h
+	}
+
+	tt := []table{
+		{name: "no leading zero bits are needed", difficulty: 0, hash: hash(), exp: true},
+		{name: "the hash has enough zero bits in the first byte", difficulty: 4, hash: hash(0x0f), exp: true},
+		{name: "the hash has too few zero bits in the first byte", difficulty: 5, hash: hash(0x0f), exp: false},
+		{name: "the hash has exactly enough zero bytes", difficulty: 16, hash: hash(0x00, 0x00), exp: true},
+		{name: "the hash has enough zero bits after a zero byte", difficulty: 15, hash: hash(0x00, 0x01), exp: true},
+		{name: "the hash has too few zero bits after a zero byte", difficulty: 16, hash: hash(0x00, 0x01), exp: false},
+		{name: "a zero bit after a set bit isn't counted", difficulty: 2, hash: hash(0x40, 0x00), exp: false},
+		{name: "the hash is all zeros at the max difficulty", difficulty: maxDifficulty, hash: make([]byte, 32), exp: true},
+		{name: "the difficulty is over the max", difficulty: maxDifficulty + 1, hash: make([]byte, 32), exp: false},
+		{name: "the difficulty is negative", difficulty: -1, hash: hash(), exp: false},
+		{name: "the hash is too short", difficulty: 0, hash: make([]byte, 31), exp: false},
+	}
+
+	t.Log("Given the need to count the leading zero bits of a hash.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen %s.", testID, test.name)
+			{
+				if got := isHashSolved(test.difficulty, test.hash); got != test.exp {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected result: got %v, exp %v", failed, testID, got, test.exp)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected result.", success, testID)
+			}
+		}
+	}
+}
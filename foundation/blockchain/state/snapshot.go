@@ -0,0 +1,273 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/accounts"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// snapshotInterval represents the number of blocks applied between the
+// snapshots of the accounts written to disk.
+const snapshotInterval = 100
+
+// keepSnapshots represents the number of snapshots kept that were taken more
+// than maxBranchDepth blocks before the latest one. A reorganization can't
+// roll the chain back past those, so older snapshots are removed.
+const keepSnapshots = 2
+
+// snapshot represents the information for all accounts after the block with
+// the specified number and hash was applied, along with the total work
+// performed on the chain up to that block.
+type snapshot struct {
+	Number   uint64                            `json:"number"`
+	Hash     string                            `json:"hash"`
+	Work     *big.Int                          `json:"work"`
+	Accounts map[storage.Account]accounts.Info `json:"accounts"`
+}
+
+// snapshots manages the account snapshots kept in a directory next to the
+// database file, one file per snapshot named by block number. Without a
+// directory, no snapshots are kept.
+type snapshots struct {
+	dir  string
+	last uint64
+}
+
+// newSnapshots constructs the snapshots kept in the specified directory,
+// creating the directory if it doesn't exist.
+func newSnapshots(dir string) (*snapshots, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &snapshots{dir: dir}, nil
+}
+
+// restore loads the accounts from the newest snapshot taken at or before the
+// specified block number that matches the chain in storage, and returns the
+// number of the block the snapshot was taken at along with the total work
+// up to that block. A snapshot matches when the block at its number has the
+// same hash and the state root of its accounts matches the block header.
+// Without a matching snapshot, 0 is returned and the accounts are left at
+// genesis.
+func (ss *snapshots) restore(strg storage.Storage, accts *accounts.Accounts, number uint64, ev EventHandler) (uint64, *big.Int, error) {
+	numbers, err := ss.numbers()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for i := len(numbers) - 1; i >= 0; i-- {
+		if numbers[i] > number {
+			continue
+		}
+
+		snap, err := ss.load(numbers[i])
+		if err != nil {
+			ev("state: snapshot[%d]: unable to load: %s", numbers[i], err)
+			continue
+		}
+
+		block, err := strg.GetBlock(snap.Number)
+		if err != nil {
+			ev("state: snapshot[%d]: unable to read block: %s", snap.Number, err)
+			continue
+		}
+
+		if block.Hash() != snap.Hash {
+			ev("state: snapshot[%d]: block hash doesn't match the chain", snap.Number)
+			continue
+		}
+
+		if snap.Work == nil || snap.Work.Sign() <= 0 {
+			ev("state: snapshot[%d]: total work is missing", snap.Number)
+			continue
+		}
+
+		restored := accts.Clone()
+		restored.Restore(snap.Accounts)
+		if restored.StateRoot() != block.Header.StateRoot {
+			ev("state: snapshot[%d]: state root doesn't match the block", snap.Number)
+			continue
+		}
+
+		accts.Replace(restored)
+
+		return snap.Number, snap.Work, nil
+	}
+
+	return 0, big.NewInt(0), nil
+}
+
+// write saves the accounts as they are after the specified block was
+// applied, along with the total work up to the block. Snapshots no longer
+// needed are removed.
+func (ss *snapshots) write(block storage.Block, work *big.Int, accts *accounts.Accounts) error {
+	if ss.dir == "" {
+		return nil
+	}
+
+	snap := snapshot{
+		Number:   block.Header.Number,
+		Hash:     block.Hash(),
+		Work:     work,
+		Accounts: accts.Copy(),
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and make sure it's durable before it
+	// replaces the snapshot, so a crash never leaves a partial snapshot
+	// behind.
+	path := ss.path(snap.Number)
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	ss.last = snap.Number
+
+	return ss.prune(snap.Number)
+}
+
+// due reports if enough blocks have been applied since the last snapshot
+// for a snapshot to be taken at the specified block number.
+func (ss *snapshots) due(number uint64) bool {
+	return ss.dir != "" && number >= ss.last+snapshotInterval
+}
+
+// truncate removes the snapshots taken after the specified block number,
+// since those blocks are no longer on the chain.
+func (ss *snapshots) truncate(number uint64) error {
+	numbers, err := ss.numbers()
+	if err != nil {
+		return err
+	}
+
+	for _, n := range numbers {
+		if n > number {
+			if err := os.Remove(ss.path(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ss.last > number {
+		ss.last = number
+	}
+
+	return nil
+}
+
+// prune removes the snapshots taken more than maxBranchDepth blocks before
+// the specified block number, except for the newest keepSnapshots of them.
+// Balances queried before the remaining snapshots are replayed from genesis.
+func (ss *snapshots) prune(number uint64) error {
+	if number <= maxBranchDepth {
+		return nil
+	}
+
+	numbers, err := ss.numbers()
+	if err != nil {
+		return err
+	}
+
+	var old []uint64
+	for _, n := range numbers {
+		if n <= number-maxBranchDepth {
+			old = append(old, n)
+		}
+	}
+
+	for len(old) > keepSnapshots {
+		if err := os.Remove(ss.path(old[0])); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+
+	return nil
+}
+
+// numbers returns the block numbers of the snapshots on disk in ascending
+// order.
+func (ss *snapshots) numbers() ([]uint64, error) {
+	if ss.dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(ss.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		number, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	return numbers, nil
+}
+
+// load reads the snapshot taken at the specified block number.
+func (ss *snapshots) load(number uint64) (snapshot, error) {
+	data, err := os.ReadFile(ss.path(number))
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshot{}, err
+	}
+
+	if snap.Number != number {
+		return snapshot{}, errors.New("block number doesn't match the file name")
+	}
+
+	return snap, nil
+}
+
+// path returns the file name for the snapshot at the specified number.
+func (ss *snapshots) path(number uint64) string {
+	return filepath.Join(ss.dir, fmt.Sprintf("%d.json", number))
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/accounts"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+func TestSnapshotPrune(t *testing.T) {
+	type table struct {
+		name   string
+		latest uint64
+		exp    []uint64
+	}
+
+	tt := []table{
+		{name: "no snapshot is old enough", latest: 100, exp: []uint64{100}},
+		{name: "a few snapshots are old enough", latest: 300, exp: []uint64{100, 200, 300}},
+		{name: "many snapshots are old enough", latest: 1000, exp: []uint64{800, 900, 1000}},
+	}
+
+	t.Log("Given the need to keep only the snapshots a node can use.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen %s.", testID, test.name)
+			{
+				dir := t.TempDir()
+				ss, err := newSnapshots(dir)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to construct the snapshots: %s", failed, testID, err)
+				}
+
+				accts := accounts.New(genesis.Genesis{})
+				for number := uint64(snapshotInterval); number <= test.latest; number += snapshotInterval {
+					block := storage.Block{Header: storage.BlockHeader{Number: number}}
+					if err := ss.write(block, big.NewInt(int64(number)), accts); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to write snapshot %d: %s", failed, testID, number, err)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to write the snapshots.", success, testID)
+
+				numbers, err := ss.numbers()
+				if err != nil || fmt.Sprint(numbers) != fmt.Sprint(test.exp) {
+					t.Fatalf("\t%s\tTest %d:\tShould keep the expected snapshots: got %v, exp %v: %v", failed, testID, numbers, test.exp, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould keep the expected snapshots.", success, testID)
+
+				entries, err := os.ReadDir(dir)
+				if err != nil || len(entries) != len(test.exp) {
+					t.Fatalf("\t%s\tTest %d:\tShould leave no other files behind: got %d, exp %d: %v", failed, testID, len(entries), len(test.exp), err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould leave no other files behind.", success, testID)
+			}
+		}
+	}
+}
@@ -2,25 +2,39 @@ package state
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/accounts"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 )
 
+// maxBlockTimeDrift represents how far into the future a block's timestamp
+// can be compared to this node's clock.
+const maxBlockTimeDrift = 2 * time.Minute
+
 // ErrNotEnoughTransactions is returned when a block is requested to be created
 // and there are not enough transactions.
 var ErrNotEnoughTransactions = errors.New("not enough transactions in mempool")
 
-// ErrChainForked is returned from validateNextBlock if another node's chain
-// is two or more blocks ahead of ours.
+// ErrTransactionNotFound is returned when a transaction can't be found in
+// any block on the chain.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// ErrChainForked is returned from validateBlock if the block builds on a
+// block this node doesn't have. This means another node is on a branch of
+// the chain we don't know about and the worker needs to retrieve it.
 var ErrChainForked = errors.New("blockchain forked, start resync")
 
 // =============================================================================
@@ -32,11 +46,17 @@ type EventHandler func(v string, args ...interface{})
 // Config represents the configuration required to start
 // the blockchain node.
 type Config struct {
-	MinerAccount storage.Account
-	Host         string
-	DBPath       string
-	KnownPeers   *peer.PeerSet
-	EvHandler    EventHandler
+	MinerAccount         storage.Account
+	Host                 string
+	DBPath               string
+	Storage              storage.Storage
+	SelectStrategy       string
+	ReplaceTipBump       uint
+	MempoolMaxSize       int
+	MempoolMaxPerAccount int
+	MempoolTTL           time.Duration
+	KnownPeers           *peer.PeerSet
+	EvHandler            EventHandler
 }
 
 // State manages the blockchain database.
@@ -48,12 +68,13 @@ type State struct {
 
 	evHandler EventHandler
 
-	genesis     genesis.Genesis
-	storage     *storage.Storage
-	mempool     *mempool.Mempool
-	accounts    *accounts.Accounts
-	latestBlock storage.Block
-	mu          sync.Mutex
+	genesis   genesis.Genesis
+	storage   storage.Storage
+	mempool   *mempool.Mempool
+	accounts  *accounts.Accounts
+	snapshots *snapshots
+	tree      *blockTree
+	mu        sync.Mutex
 
 	worker *worker
 }
@@ -68,54 +89,113 @@ func New(cfg Config) (*State, error) {
 		return nil, err
 	}
 
-	// Access the storage for the blockchain.
-	strg, err := storage.New(cfg.DBPath)
+	// The difficulty is retargeted from the blocks held in the block tree,
+	// so the tree must be able to hold an entire interval.
+	if genesis.RetargetInterval > maxBranchDepth+1 {
+		return nil, fmt.Errorf("retarget interval %d is larger than the maximum of %d", genesis.RetargetInterval, maxBranchDepth+1)
+	}
+
+	// Build a safe event handler function for use.
+	ev := func(v string, args ...interface{}) {
+		if cfg.EvHandler != nil {
+			cfg.EvHandler(v, args...)
+		}
+	}
+
+	// The mempool journal and the account snapshots are kept next to the
+	// database so they survive a restart. Without a database path, neither
+	// is kept.
+	var journalPath string
+	var snapshotDir string
+	if cfg.DBPath != "" {
+		base := strings.TrimSuffix(cfg.DBPath, filepath.Ext(cfg.DBPath))
+		journalPath = base + ".mempool"
+		snapshotDir = base + ".snapshots"
+	}
+
+	snapshots, err := newSnapshots(snapshotDir)
 	if err != nil {
 		return nil, err
 	}
 
-	// Load all existing blocks from storage into memory for processing. This
-	// won't work in a system like Ethereum.
-	blocks, err := strg.ReadAllBlocks()
+	// Create a new accounts value to manage accounts who transact on
+	// the blockchain. Start from the newest snapshot that matches the
+	// chain so only the blocks after it need to be replayed.
+	accounts := accounts.New(genesis)
+	snapNumber, snapWork, err := snapshots.restore(cfg.Storage, accounts, QueryLastest, ev)
 	if err != nil {
 		return nil, err
 	}
-
-	// Keep the latest block from the blockchain.
-	var latestBlock storage.Block
-	if len(blocks) > 0 {
-		latestBlock = blocks[len(blocks)-1]
+	if snapNumber > 0 {
+		ev("state: New: accounts restored from snapshot[%d]", snapNumber)
 	}
+	snapshots.last = snapNumber
+
+	// Create a block tree to track the work performed on the chain. The
+	// tree holds the same blocks it would after replaying the whole chain,
+	// so it starts from the blocks leading up to the snapshot.
+	var root storage.Block
+	if snapNumber > maxBranchDepth {
+		if root, err = cfg.Storage.GetBlock(snapNumber - maxBranchDepth); err != nil {
+			return nil, err
+		}
+	}
+	tree := newBlockTree(root)
+
+	// Read the blocks from storage one at a time and process the
+	// transactions for each account after the snapshot. The latest block
+	// in storage becomes the head of the tree.
+	for number := root.Header.Number + 1; ; number++ {
+		block, err := cfg.Storage.GetBlock(number)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				break
+			}
+			return nil, err
+		}
 
-	// Create a new accounts value to manage accounts who transact on
-	// the blockchain.
-	accounts := accounts.New(genesis)
+		if number > snapNumber {
+			for _, tx := range block.Transactions {
 
-	// Process the blocks and transactions for each account.
-	for _, block := range blocks {
-		for _, tx := range block.Transactions {
+				// Apply the balance changes based for this transaction.
+				accounts.ApplyTransaction(block.Header.MinerAccount, tx)
+			}
 
-			// Apply the balance changes based for this transaction.
-			accounts.ApplyTransaction(block.Header.MinerAccount, tx)
+			// Apply the mining reward for this block.
+			accounts.ApplyMiningReward(block.Header.MinerAccount)
 		}
 
-		// Apply the mining reward for this block.
-		accounts.ApplyMiningReward(block.Header.MinerAccount)
+		// Add the block to the tree as the new head.
+		nd, err := tree.add(block.Hash(), block)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", block.Header.Number, err)
+		}
+		tree.head = nd
+		tree.prune()
+
+		// The work for the blocks before the root of the tree is only known
+		// from the snapshot, so the work in the tree is made to match it.
+		if number == snapNumber {
+			tree.setWork(nd, snapWork)
+		}
 	}
 
-	// Construct a mempool with the specified sort strategy.
-	mempool, err := mempool.New()
+	// Construct a mempool with the specified sort strategy. The accounts
+	// decide which transactions can be mined next.
+	mempool, err := mempool.New(mempool.Config{
+		SelectStrategy: cfg.SelectStrategy,
+		ReplaceTipBump: cfg.ReplaceTipBump,
+		MaxSize:        cfg.MempoolMaxSize,
+		MaxPerAccount:  cfg.MempoolMaxPerAccount,
+		TTL:            cfg.MempoolTTL,
+		JournalPath:    journalPath,
+		NonceFunc:      accounts.Nonce,
+		EvHandler:      ev,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Build a safe event handler function for use.
-	ev := func(v string, args ...interface{}) {
-		if cfg.EvHandler != nil {
-			cfg.EvHandler(v, args...)
-		}
-	}
-
 	// Create the State to provide support for managing the blockchain.
 	state := State{
 		minerAccount: cfg.MinerAccount,
@@ -124,11 +204,17 @@ func New(cfg Config) (*State, error) {
 		knownPeers:   cfg.KnownPeers,
 		evHandler:    ev,
 
-		genesis:     genesis,
-		storage:     strg,
-		mempool:     mempool,
-		accounts:    accounts,
-		latestBlock: latestBlock,
+		genesis:   genesis,
+		storage:   cfg.Storage,
+		mempool:   mempool,
+		accounts:  accounts,
+		snapshots: snapshots,
+		tree:      tree,
+	}
+
+	// Load the transactions that were in the mempool when the node stopped.
+	if err := state.loadMempool(journalPath); err != nil {
+		return nil, err
 	}
 
 	// Run the worker which will assign itself to this state.
@@ -148,22 +234,74 @@ func (s *State) Shutdown() error {
 	// Stop all blockchain writing activity.
 	s.worker.shutdown()
 
+	// Take a snapshot of the accounts so the next start doesn't need to
+	// replay any blocks.
+	s.mu.Lock()
+	if head := s.tree.head.block; head.Header.Number > s.snapshots.last {
+		if err := s.snapshots.write(head, s.tree.head.work, s.accounts); err != nil {
+			s.evHandler("state: Shutdown: write snapshot: ERROR: %s", err)
+		}
+	}
+	s.mu.Unlock()
+
+	// Compact the mempool journal so it only holds the transactions that
+	// are still waiting to be mined.
+	if err := s.mempool.Rotate(); err != nil {
+		s.evHandler("state: Shutdown: rotate mempool journal: ERROR: %s", err)
+	}
+	s.mempool.Close()
+
 	return nil
 }
 
+// loadMempool adds the transactions from the mempool journal back into the
+// mempool. Transactions that have been mined or are no longer valid are
+// skipped. The journal is then rewritten to match the mempool.
+func (s *State) loadMempool(journalPath string) error {
+	trans, err := mempool.ReadJournal(journalPath)
+	if err != nil {
+		s.evHandler("state: loadMempool: WARNING: journal only partially read: %s", err)
+	}
+
+	for _, tx := range trans {
+		if err := s.validateTransaction(&tx.SignedTx); err != nil {
+			s.evHandler("state: loadMempool: tx[%s] skipped: %s", tx, err)
+			continue
+		}
+
+		if err := s.validateAdmission(tx.BlockTx); err != nil {
+			s.evHandler("state: loadMempool: tx[%s] skipped: %s", tx, err)
+			continue
+		}
+
+		if _, _, err := s.mempool.UpsertJournal(tx); err != nil {
+			s.evHandler("state: loadMempool: tx[%s] skipped: %s", tx, err)
+		}
+	}
+
+	s.evHandler("state: loadMempool: loaded %d of %d transactions", s.mempool.Count(), len(trans))
+
+	return s.mempool.Rotate()
+}
+
 // =============================================================================
 
 // SubmitWalletTransaction accepts a transaction from a wallet for inclusion.
-func (s *State) SubmitWalletTransaction(signedTx storage.SignedTx) error {
-	if err := s.validateTransaction(signedTx); err != nil {
-		return err
+// The transaction is only shared with peers if it's accepted by the mempool.
+func (s *State) SubmitWalletTransaction(signedTx storage.SignedTx) (mempool.Status, error) {
+	if err := s.validateTransaction(&signedTx); err != nil {
+		return "", err
 	}
 
 	tx := storage.NewBlockTx(signedTx, s.genesis.GasPrice)
 
-	n, err := s.mempool.Upsert(tx)
+	if err := s.validateAdmission(tx); err != nil {
+		return "", err
+	}
+
+	status, n, err := s.mempool.Upsert(tx)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	s.worker.signalShareTransactions(tx)
@@ -172,25 +310,29 @@ func (s *State) SubmitWalletTransaction(signedTx storage.SignedTx) error {
 		s.worker.signalStartMining()
 	}
 
-	return nil
+	return status, nil
 }
 
 // SubmitNodeTransaction accepts a transaction from a node for inclusion.
-func (s *State) SubmitNodeTransaction(tx storage.BlockTx) error {
-	if err := s.validateTransaction(tx.SignedTx); err != nil {
-		return err
+func (s *State) SubmitNodeTransaction(tx storage.BlockTx) (mempool.Status, error) {
+	if err := s.validateTransaction(&tx.SignedTx); err != nil {
+		return "", err
 	}
 
-	n, err := s.mempool.Upsert(tx)
+	if err := s.validateAdmission(tx); err != nil {
+		return "", err
+	}
+
+	status, n, err := s.mempool.Upsert(tx)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if n >= s.genesis.TransPerBlock {
 		s.worker.signalStartMining()
 	}
 
-	return nil
+	return status, nil
 }
 
 // =============================================================================
@@ -201,21 +343,67 @@ func (s *State) MineNewBlock(ctx context.Context) (storage.Block, time.Duration,
 	s.evHandler("state: MineNewBlock: MINING: check mempool count")
 
 	// Are there enough transactions in the pool.
-	if s.mempool.Count() < s.genesis.TransPerBlock {
+	if s.mempool.CountPending() < s.genesis.TransPerBlock {
 		return storage.Block{}, 0, ErrNotEnoughTransactions
 	}
 
 	s.evHandler("state: MineNewBlock: MINING: create new block: pick %d", s.genesis.TransPerBlock)
 
+	// Capture the latest block, the difficulty required to build on it and
+	// a copy of the accounts to check the transactions against.
+	var latestBlock storage.Block
+	var difficulty int
+	var scratch *accounts.Accounts
+	var err error
+	s.mu.Lock()
+	{
+		latestBlock = s.tree.head.block
+		difficulty, err = retarget(s.genesis, s.tree.head)
+		scratch = s.accounts.Clone()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return storage.Block{}, 0, err
+	}
+
+	// Only keep the transactions that can be applied to the accounts, so
+	// the block won't be rejected by our peers. Once a transaction for an
+	// account fails, the account's later transactions are left for a
+	// future block since their nonces are now out of order.
+	var trans []storage.BlockTx
+	failed := make(map[storage.Account]bool)
+	for _, tx := range s.mempool.PickBest(s.genesis.TransPerBlock) {
+		from, err := tx.FromAccount()
+		if err != nil || failed[from] {
+			continue
+		}
+
+		if err := scratch.ApplyTransaction(s.minerAccount, tx); err != nil {
+			s.evHandler("state: MineNewBlock: MINING: WARNING: remove tx[%s] from mempool: %s", tx, err)
+			s.mempool.Delete(tx)
+			failed[from] = true
+			continue
+		}
+		trans = append(trans, tx)
+	}
+
+	if len(trans) == 0 {
+		return storage.Block{}, 0, ErrNotEnoughTransactions
+	}
+
+	// Apply the reward so the scratch accounts match the state after the
+	// block is applied and the state root can be calculated.
+	scratch.ApplyMiningReward(s.minerAccount)
+
 	// Create a new block which owns it's own copy of the transactions.
-	trans := s.mempool.PickBest(s.genesis.TransPerBlock)
-	nb := storage.NewBlock(s.minerAccount, s.genesis.Difficulty, s.genesis.TransPerBlock, s.RetrieveLatestBlock(), trans)
+	nb := storage.NewBlock(s.minerAccount, difficulty, s.genesis.TransPerBlock, latestBlock, trans, scratch.StateRoot())
 
-	s.evHandler("state: MineNewBlock: MINING: perform POW")
+	s.evHandler("state: MineNewBlock: MINING: perform POW: difficulty[%d]", difficulty)
 
 	// Attempt to create a new BlockFS by solving the POW puzzle.
 	// This can be cancelled.
-	blockFS, duration, err := performPOW(ctx, s.genesis.Difficulty, nb, s.evHandler)
+	blockFS, duration, err := performPOW(ctx, difficulty, nb, s.evHandler)
 	if err != nil {
 		return storage.Block{}, duration, err
 	}
@@ -252,6 +440,9 @@ func (s *State) MinePeerBlock(block storage.Block) error {
 
 	hash, err := s.validateBlock(block)
 	if err != nil {
+		if errors.Is(err, ErrChainForked) {
+			s.worker.signalResolveFork()
+		}
 		return err
 	}
 
@@ -263,105 +454,380 @@ func (s *State) MinePeerBlock(block storage.Block) error {
 	return s.updateLocalState(blockFS)
 }
 
-// updateLocalState takes the blockFS and updates the current state of the
-// chain, including adding the block to disk.
+// updateLocalState takes the blockFS and adds it to the block tree. If the
+// block extends the canonical chain, or creates a branch with more work than
+// the canonical chain, the block is validated against the accounts and the
+// current state of the chain is updated, including adding the block to disk.
 func (s *State) updateLocalState(blockFS storage.BlockFS) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.evHandler("state: updateLocalState: write to disk")
+	s.evHandler("state: updateLocalState: add to block tree")
 
-	// Write the new block to the chain on disk.
-	if err := s.storage.Write(blockFS); err != nil {
+	nd, err := s.tree.add(blockFS.Hash, blockFS.Block)
+	if err != nil {
 		return err
 	}
-	s.latestBlock = blockFS.Block
 
-	s.evHandler("state: updateLocalState: update accounts and remove from mempool")
+	switch {
+	case nd.parent == s.tree.head:
+		s.evHandler("state: updateLocalState: validate block against accounts")
 
-	// Process the transactions and update the accounts.
-	for _, tx := range blockFS.Block.Transactions {
-		s.evHandler("state: updateLocalState: tx[%s] update and remove", tx)
+		scratch := s.accounts.Clone()
+		if err := s.applyBlock(scratch, nd.parent.block, nd.block); err != nil {
+			s.tree.remove(nd)
+			return err
+		}
 
-		// Apply the balance changes based on this transaction.
-		if err := s.accounts.ApplyTransaction(blockFS.Block.Header.MinerAccount, tx); err != nil {
-			s.evHandler("state: updateLocalState: WARNING : %s", err)
-			continue
+		if err := s.writeBlock(nd); err != nil {
+			return err
 		}
+		s.tree.prune()
+		s.accounts.Replace(scratch)
+		s.mempool.Promote()
+		s.snapshot()
 
-		// Remove this transaction from the mempool.
-		s.mempool.Delete(tx)
+		return nil
+
+	case nd.work.Cmp(s.tree.head.work) > 0:
+		return s.reorganize(nd)
 	}
 
-	s.evHandler("state: updateLocalState: apply mining reward")
+	s.evHandler("state: updateLocalState: block[%d] kept on side branch: work[%s]: head work[%s]", nd.block.Header.Number, nd.work, s.tree.head.work)
 
-	// Apply the mining reward for this block.
-	s.accounts.ApplyMiningReward(blockFS.Block.Header.MinerAccount)
+	return nil
+}
+
+// writeBlock writes the block held by the node to disk and makes it the new
+// head of the chain. The block's transactions are removed from the mempool.
+// The caller must hold the state lock, update the accounts and prune the
+// tree.
+func (s *State) writeBlock(nd *node) error {
+	s.evHandler("state: writeBlock: write to disk: block[%d]", nd.block.Header.Number)
+
+	// Write the new block to the chain on disk.
+	blockFS := storage.BlockFS{
+		Hash:  nd.hash,
+		Block: nd.block,
+	}
+	if err := s.storage.Write(blockFS); err != nil {
+		return err
+	}
+	s.tree.head = nd
+
+	s.evHandler("state: writeBlock: remove from mempool")
+
+	for _, tx := range nd.block.Transactions {
+		s.evHandler("state: writeBlock: tx[%s] remove", tx)
+		s.mempool.Delete(tx)
+	}
 
 	return nil
 }
 
+// snapshot writes a snapshot of the accounts once enough blocks have been
+// applied since the last one. The caller must hold the state lock.
+func (s *State) snapshot() {
+	head := s.tree.head.block
+	if !s.snapshots.due(head.Header.Number) {
+		return
+	}
+
+	s.evHandler("state: snapshot: block[%d]", head.Header.Number)
+
+	if err := s.snapshots.write(head, s.tree.head.work, s.accounts); err != nil {
+		s.evHandler("state: snapshot: ERROR: %s", err)
+	}
+}
+
 // validateBlock takes a block and validates it to be included into
 // the blockchain.
 func (s *State) validateBlock(block storage.Block) (string, error) {
 	s.evHandler("state: WriteNextBlock: validate: hash solved")
 
 	hash := block.Hash()
-	if !isHashSolved(block.Header.Difficulty, hash) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || !isHashSolved(block.Header.Difficulty, hashBytes) {
 		return signature.ZeroHash, fmt.Errorf("%s invalid hash", hash)
 	}
 
-	latestBlock := s.RetrieveLatestBlock()
-	nextNumber := latestBlock.Header.Number + 1
+	var known bool
+	var parent *node
+	var parentKnown bool
+	var difficulty int
+	var retargetErr error
+	s.mu.Lock()
+	{
+		_, known = s.tree.lookup(hash)
+		parent, parentKnown = s.tree.lookup(block.Header.ParentHash)
+		if parentKnown {
+			difficulty, retargetErr = retarget(s.genesis, parent)
+		}
+	}
+	s.mu.Unlock()
+
+	if known {
+		return signature.ZeroHash, errBlockExists
+	}
 
 	s.evHandler("state: WriteNextBlock: validate: chain not forked")
 
-	// The node who sent this block has a chain that is two or more blocks ahead
-	// of ours. This means there has been a fork and we are on the wrong side.
-	if block.Header.Number >= (nextNumber + 2) {
+	// The node who sent this block has a branch of the chain we don't
+	// know about. We need to retrieve that branch to compare its work.
+	if !parentKnown {
+		s.evHandler("state: WriteNextBlock: validate: unknown parent block %s", block.Header.ParentHash)
 		return signature.ZeroHash, ErrChainForked
 	}
 
 	s.evHandler("state: WriteNextBlock: validate: block number")
 
+	nextNumber := parent.block.Header.Number + 1
 	if block.Header.Number != nextNumber {
 		return signature.ZeroHash, fmt.Errorf("this block is not the next number, got %d, exp %d", block.Header.Number, nextNumber)
 	}
 
-	s.evHandler("state: WriteNextBlock: validate: parent hash")
+	s.evHandler("state: WriteNextBlock: validate: difficulty")
+
+	if retargetErr != nil {
+		return signature.ZeroHash, retargetErr
+	}
 
-	if block.Header.ParentHash != latestBlock.Hash() {
-		return signature.ZeroHash, fmt.Errorf("prev block doesn't match our latest, got %s, exp %s", block.Header.ParentHash, latestBlock.Hash())
+	if block.Header.Difficulty != difficulty {
+		return signature.ZeroHash, fmt.Errorf("block has the wrong difficulty, got %d, exp %d", block.Header.Difficulty, difficulty)
+	}
+
+	s.evHandler("state: WriteNextBlock: validate: transaction root")
+
+	if transRoot := storage.TransRoot(block.Transactions); block.Header.TransRoot != transRoot {
+		return signature.ZeroHash, fmt.Errorf("block has the wrong transaction root, got %s, exp %s", block.Header.TransRoot, transRoot)
 	}
 
 	s.evHandler("state: WriteNextBlock: validate: transaction signatures")
 
-	for _, tx := range block.Transactions {
-		if err := s.validateTransaction(tx.SignedTx); err != nil {
-			return signature.ZeroHash, fmt.Errorf("transaction has invalid signature or other problems, %w, tx[%v]", err, tx)
+	// The transactions are validated in place so the accounts recovered
+	// from the signatures are kept when the block is applied.
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if err := s.validateTransaction(&tx.SignedTx); err != nil {
+			return signature.ZeroHash, fmt.Errorf("transaction has invalid signature or other problems, %w, tx[%v]", err, *tx)
 		}
 	}
 
 	return hash, nil
 }
 
+// applyBlock replays the block against the specified accounts to validate
+// the economics of the block. The transactions must apply cleanly and the
+// header totals, timestamp and miner must be correct. If an error is
+// returned, the accounts are left in a partially applied state.
+func (s *State) applyBlock(accts *accounts.Accounts, parent storage.Block, block storage.Block) error {
+	if len(block.Transactions) > s.genesis.TransPerBlock {
+		return fmt.Errorf("too many transactions, got %d, exp <= %d", len(block.Transactions), s.genesis.TransPerBlock)
+	}
+
+	if !block.Header.MinerAccount.IsAccount() {
+		return fmt.Errorf("invalid miner account %s", block.Header.MinerAccount)
+	}
+
+	if block.Header.TimeStamp < parent.Header.TimeStamp {
+		return fmt.Errorf("block timestamp is before its parent, got %d, parent %d", block.Header.TimeStamp, parent.Header.TimeStamp)
+	}
+
+	if limit := uint64(time.Now().Add(maxBlockTimeDrift).UTC().Unix()); block.Header.TimeStamp > limit {
+		return fmt.Errorf("block timestamp is too far in the future, got %d, exp <= %d", block.Header.TimeStamp, limit)
+	}
+
+	var totalTip uint
+	var totalGas uint
+	for _, tx := range block.Transactions {
+		if tx.ChainID != s.genesis.ChainID {
+			return fmt.Errorf("tx[%s] %w, got %d, exp %d", tx, storage.ErrInvalidChainID, tx.ChainID, s.genesis.ChainID)
+		}
+
+		if tx.Gas != s.genesis.GasPrice {
+			return fmt.Errorf("tx[%s] has the wrong gas, got %d, exp %d", tx, tx.Gas, s.genesis.GasPrice)
+		}
+
+		if tx.TimeStamp > block.Header.TimeStamp {
+			return fmt.Errorf("tx[%s] timestamp is after the block timestamp, got %d, block %d", tx, tx.TimeStamp, block.Header.TimeStamp)
+		}
+
+		if err := accts.ApplyTransaction(block.Header.MinerAccount, tx); err != nil {
+			return fmt.Errorf("tx[%s] can't be applied: %w", tx, err)
+		}
+
+		totalTip += tx.Tip
+		totalGas += tx.Gas
+	}
+
+	if totalTip != block.Header.TotalTip {
+		return fmt.Errorf("block has the wrong total tip, got %d, exp %d", block.Header.TotalTip, totalTip)
+	}
+
+	if totalGas != block.Header.TotalGas {
+		return fmt.Errorf("block has the wrong total gas, got %d, exp %d", block.Header.TotalGas, totalGas)
+	}
+
+	accts.ApplyMiningReward(block.Header.MinerAccount)
+
+	if stateRoot := accts.StateRoot(); stateRoot != block.Header.StateRoot {
+		return fmt.Errorf("block has the wrong state root, got %s, exp %s", block.Header.StateRoot, stateRoot)
+	}
+
+	return nil
+}
+
 // =============================================================================
 
-// Truncate resets the chain both on disk and in memory. This is used to
-// correct an identified fork.
-func (s *State) Truncate() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// reorganize switches the canonical chain to the branch ending with the
+// specified node. The blocks on the new branch are validated against the
+// accounts as they were at the block the branches have in common. Then the
+// chain is rolled back to that block and the new branch is applied. If the
+// new branch can't be written, the old branch is put back. The caller must
+// hold the state lock.
+func (s *State) reorganize(nd *node) error {
+	ancestor := s.tree.ancestor(s.tree.head, nd)
+
+	s.evHandler("state: reorganize: started: ancestor[%d]: head[%d]: new head[%d]", ancestor.block.Header.Number, s.tree.head.block.Header.Number, nd.block.Header.Number)
+	defer s.evHandler("state: reorganize: completed")
+
+	scratch, err := s.accountsAt(ancestor.block.Header.Number)
+	if err != nil {
+		return err
+	}
 
-	// Reset the state of the database.
-	// s.mempool.Truncate()
-	// s.accounts.Reset()
-	// s.latestBlock = storage.Block{}
-	// s.storage.Reset()
+	branch := s.tree.branch(ancestor, nd)
+	for _, bn := range branch {
+		if err := s.applyBlock(scratch, bn.parent.block, bn.block); err != nil {
+			s.tree.remove(bn)
+			return fmt.Errorf("branch block[%d] is invalid: %w", bn.block.Header.Number, err)
+		}
+	}
+
+	// The tree isn't pruned until the new branch is written, so the old
+	// branch is still there if it needs to be put back.
+	oldBranch := s.tree.branch(ancestor, s.tree.head)
+
+	orphans, err := s.rollback(ancestor)
+	if err != nil {
+		return s.restore(ancestor, oldBranch, err)
+	}
+
+	for _, bn := range branch {
+		s.evHandler("state: reorganize: apply block[%d]: %s", bn.block.Header.Number, bn.hash)
+
+		if err := s.writeBlock(bn); err != nil {
+			return s.restore(ancestor, oldBranch, err)
+		}
+	}
+	s.tree.prune()
+	s.accounts.Replace(scratch)
+
+	// Return the orphaned transactions to the mempool now the accounts are
+	// up to date. Transactions also mined on the new branch are rejected.
+	for _, tx := range orphans {
+		if _, _, err := s.mempool.Upsert(tx); err != nil {
+			s.evHandler("state: reorganize: orphaned tx[%s] dropped: %s", tx, err)
+		}
+	}
+	s.mempool.Promote()
+	s.snapshot()
 
 	return nil
 }
 
+// restore puts the old branch back after the new branch failed to be written
+// during a reorganization, and returns the error that caused it. If the old
+// branch can't be written either, the chain is left at whatever block storage
+// ended up at and the accounts are rebuilt to match. The caller must hold the
+// state lock.
+func (s *State) restore(ancestor *node, oldBranch []*node, cause error) error {
+	s.evHandler("state: restore: started: ancestor[%d]: ERROR: %s", ancestor.block.Header.Number, cause)
+	defer s.evHandler("state: restore: completed")
+
+	err := s.storage.Reset(ancestor.block.Header.Number)
+	if err == nil {
+		s.tree.head = ancestor
+		for _, bn := range oldBranch {
+			if err = s.writeBlock(bn); err != nil {
+				break
+			}
+		}
+	}
+	s.tree.prune()
+
+	// The accounts still match the old branch, so they only need to be
+	// rebuilt when it couldn't be put back. The head of the tree is only
+	// moved once storage has been changed, so the two always match.
+	if err != nil {
+		s.evHandler("state: restore: put back old branch: ERROR: %s", err)
+
+		accts, err := s.accountsAt(s.tree.head.block.Header.Number)
+		if err != nil {
+			return fmt.Errorf("reorganize: %s: rebuild accounts: %w", cause, err)
+		}
+		s.accounts.Replace(accts)
+		s.mempool.Promote()
+	}
+
+	return fmt.Errorf("reorganize: %w", cause)
+}
+
+// rollback removes every block after the specified node from disk. The
+// transactions from the orphaned blocks are returned so they can be put
+// back in the mempool and mined again. The caller must hold the state lock.
+func (s *State) rollback(ancestor *node) ([]storage.BlockTx, error) {
+	s.evHandler("state: rollback: started: block[%d]", ancestor.block.Header.Number)
+	defer s.evHandler("state: rollback: completed")
+
+	// Collect the transactions from the orphaned blocks.
+	var orphans []storage.BlockTx
+	for _, nd := range s.tree.branch(ancestor, s.tree.head) {
+		for _, tx := range nd.block.Transactions {
+			s.evHandler("state: rollback: orphaned block[%d]: tx[%s]", nd.block.Header.Number, tx)
+			orphans = append(orphans, tx)
+		}
+	}
+
+	if err := s.storage.Reset(ancestor.block.Header.Number); err != nil {
+		return nil, err
+	}
+	s.tree.head = ancestor
+
+	if err := s.snapshots.truncate(ancestor.block.Header.Number); err != nil {
+		s.evHandler("state: rollback: remove snapshots: ERROR: %s", err)
+	}
+
+	return orphans, nil
+}
+
+// accountsAt rebuilds the accounts as they were after the block with the
+// specified number was applied. The newest snapshot taken at or before the
+// block is used so only the blocks after the snapshot are replayed. Storage
+// has its own lock, so the state lock isn't needed to read the blocks.
+func (s *State) accountsAt(number uint64) (*accounts.Accounts, error) {
+	accts := accounts.New(s.genesis)
+
+	snapNumber, _, err := s.snapshots.restore(s.storage, accts, number, s.evHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := snapNumber + 1; n <= number; n++ {
+		block, err := s.storage.GetBlock(n)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range block.Transactions {
+			accts.ApplyTransaction(block.Header.MinerAccount, tx)
+		}
+		accts.ApplyMiningReward(block.Header.MinerAccount)
+	}
+
+	return accts, nil
+}
+
 // =============================================================================
 
 // RetrieveMempool returns a copy of the mempool.
@@ -384,7 +850,16 @@ func (s *State) RetrieveLatestBlock() storage.Block {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.latestBlock
+	return s.tree.head.block
+}
+
+// RetrieveLatestWork returns the total work performed on the chain up to
+// the latest block.
+func (s *State) RetrieveLatestWork() *big.Int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return new(big.Int).Set(s.tree.head.work)
 }
 
 // RetrieveKnownPeers retrieves a copy of the known peer list.
@@ -403,23 +878,24 @@ func (s *State) QueryMempoolLength() int {
 }
 
 // QueryBlocksByNumber returns the set of blocks based on block numbers. This
-// function reads the blockchain from disk first.
+// function reads the blockchain from storage.
 func (s *State) QueryBlocksByNumber(from uint64, to uint64) []storage.Block {
-	blocks, err := s.storage.ReadAllBlocks()
-	if err != nil {
-		return nil
-	}
-
 	if from == QueryLastest {
-		from = blocks[len(blocks)-1].Header.Number
+		from = s.RetrieveLatestBlock().Header.Number
 		to = from
 	}
 
+	if latest := s.RetrieveLatestBlock().Header.Number; to > latest {
+		to = latest
+	}
+
 	var out []storage.Block
-	for _, block := range blocks {
-		if block.Header.Number >= from && block.Header.Number <= to {
-			out = append(out, block)
+	for number := from; number <= to; number++ {
+		block, err := s.storage.GetBlock(number)
+		if err != nil {
+			continue
 		}
+		out = append(out, block)
 	}
 
 	return out
@@ -437,45 +913,174 @@ func (s *State) QueryAccounts(account storage.Account) map[storage.Account]accou
 	return final
 }
 
+// QueryAccountsAt returns a copy of the account information as it was after
+// the block with the specified number was applied, along with the block. An
+// empty account returns the information for every account.
+func (s *State) QueryAccountsAt(account storage.Account, number uint64) (storage.Block, map[storage.Account]accounts.Info, error) {
+	var latestNumber uint64
+	s.mu.Lock()
+	{
+		latestNumber = s.tree.head.block.Header.Number
+	}
+	s.mu.Unlock()
+
+	if number > latestNumber {
+		return storage.Block{}, nil, storage.ErrNotFound
+	}
+
+	// The blocks are replayed without holding the state lock so a query for
+	// an old block doesn't stall mining and block validation.
+	var block storage.Block
+	if number > 0 {
+		var err error
+		if block, err = s.storage.GetBlock(number); err != nil {
+			return storage.Block{}, nil, err
+		}
+	}
+
+	accts, err := s.accountsAt(number)
+	if err != nil {
+		return storage.Block{}, nil, err
+	}
+
+	// The chain may have been reorganized while the blocks were replayed,
+	// in which case the accounts can be a mix of both branches.
+	if number > 0 {
+		current, err := s.storage.GetBlock(number)
+		if err != nil || current.Hash() != block.Hash() {
+			return storage.Block{}, nil, fmt.Errorf("block %d changed while replaying the chain, try again", number)
+		}
+	}
+
+	cpy := accts.Copy()
+	if account == "" {
+		return block, cpy, nil
+	}
+
+	final := make(map[storage.Account]accounts.Info)
+	if info, exists := cpy[account]; exists {
+		final[account] = info
+	}
+
+	return block, final, nil
+}
+
+// QueryAccountProof returns the information for the specified account along
+// with a merkle proof the information is included in the state root of the
+// latest block.
+func (s *State) QueryAccountProof(account storage.Account) (storage.Block, accounts.Info, []merkle.Step, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, proof, err := s.accounts.Proof(account)
+	if err != nil {
+		return storage.Block{}, accounts.Info{}, nil, err
+	}
+
+	return s.tree.head.block, info, proof, nil
+}
+
 // QueryBlocksByAccount returns the set of blocks by account. If the account
 // is empty, all blocks are returned. This function reads the blockchain
-// from disk first.
+// from storage.
 func (s *State) QueryBlocksByAccount(account storage.Account) []storage.Block {
-	blocks, err := s.storage.ReadAllBlocks()
-	if err != nil {
-		return nil
+	if account != "" {
+		blocks, err := s.storage.GetBlocksByAccount(account)
+		if err != nil {
+			return nil
+		}
+		return blocks
 	}
 
 	var out []storage.Block
-blocks:
-	for _, block := range blocks {
-		for _, tx := range block.Transactions {
-			from, err := tx.FromAccount()
-			if err != nil {
-				continue
-			}
-			if account == "" || from == account || tx.To == account {
-				out = append(out, block)
-				continue blocks
-			}
+	iter := s.storage.ForEach()
+	for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+		if err != nil {
+			return nil
 		}
+		out = append(out, block)
 	}
 
 	return out
 }
 
+// QueryBlockByTransaction returns the block that includes the transaction with
+// the specified signature, along with the index of the transaction in the
+// block. This function reads the blockchain from storage.
+func (s *State) QueryBlockByTransaction(sig string) (storage.Block, int, error) {
+	block, index, err := s.storage.GetBlockByTransaction(sig)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return storage.Block{}, 0, ErrTransactionNotFound
+		}
+		return storage.Block{}, 0, err
+	}
+
+	return block, index, nil
+}
+
 // =============================================================================
 
 // validateTransaction takes the signed transaction and validates it has
 // a proper signature and other aspects of the data.
-func (s *State) validateTransaction(signedTx storage.SignedTx) error {
-	if err := signedTx.Validate(); err != nil {
+func (s *State) validateTransaction(signedTx *storage.SignedTx) error {
+	if err := signedTx.Validate(s.genesis.ChainID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateAdmission checks the transaction can be mined on top of the current
+// state of the accounts. The sender must be able to pay for the transaction
+// along with their other transactions already in the mempool. A transaction
+// being replaced doesn't count towards the cost.
+func (s *State) validateAdmission(tx storage.BlockTx) error {
+	if err := s.accounts.ValidateNonce(tx.SignedTx); err != nil {
+		return err
+	}
+
+	if tx.Gas != s.genesis.GasPrice {
+		return fmt.Errorf("invalid gas, got %d, exp %d", tx.Gas, s.genesis.GasPrice)
+	}
+
+	from, err := tx.FromAccount()
+	if err != nil {
 		return err
 	}
 
+	cost, ok := addCost(0, tx)
+	var count int
+	for _, mtx := range s.mempool.ByAccount(from) {
+		if mtx.Nonce == tx.Nonce {
+			continue
+		}
+		if cost, ok = addCost(cost, mtx); !ok {
+			break
+		}
+		count++
+	}
+
+	if balance := s.accounts.Balance(from); !ok || cost > balance {
+		return fmt.Errorf("insufficient balance for %s, balance %d, cost of this and %d other transactions in the mempool exceeds it", from, balance, count)
+	}
+
 	return nil
 }
 
+// addCost adds the value, gas and tip of the transaction to the total. False
+// is returned if the total overflows.
+func addCost(total uint, tx storage.BlockTx) (uint, bool) {
+	for _, v := range []uint{tx.Value, tx.Gas, tx.Tip} {
+		if total+v < total {
+			return 0, false
+		}
+		total += v
+	}
+
+	return total, true
+}
+
 // =============================================================================
 
 // addPeerNode adds an peer to the list of peers.
@@ -489,3 +1094,34 @@ func (s *State) addPeerNode(peer peer.Peer) error {
 	s.knownPeers.Add(peer)
 	return nil
 }
+
+// isHeavierChain reports if a chain with the specified total work has more
+// work than the canonical chain.
+func (s *State) isHeavierChain(work *big.Int) bool {
+	if work == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return work.Cmp(s.tree.head.work) > 0
+}
+
+// rootNumber returns the number of the oldest block held in the block tree.
+// A fork can only be resolved back to this block.
+func (s *State) rootNumber() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tree.root.block.Header.Number
+}
+
+// isKnownBlock reports whether the block is held in the block tree.
+func (s *State) isKnownBlock(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, exists := s.tree.lookup(hash)
+	return exists
+}
@@ -0,0 +1,237 @@
+package state
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/accounts"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// Miners used to tell the blocks on competing branches apart.
+const (
+	minerA = storage.Account("0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9")
+	minerB = storage.Account("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+)
+
+// errWrite is returned by failStorage for the blocks set to fail.
+var errWrite = errors.New("write failed")
+
+// failStorage fails to write the blocks with the specified hashes.
+type failStorage struct {
+	storage.Storage
+	fail map[string]bool
+}
+
+func (fs *failStorage) Write(blockFS storage.BlockFS) error {
+	if fs.fail[blockFS.Hash] {
+		return errWrite
+	}
+
+	return fs.Storage.Write(blockFS)
+}
+
+// newTestState constructs a state at genesis using the specified storage.
+func newTestState(t *testing.T, strg storage.Storage) *State {
+	gen := genesis.Genesis{
+		ChainID:       1,
+		Difficulty:    1,
+		TransPerBlock: 2,
+		MiningReward:  700,
+	}
+
+	accts := accounts.New(gen)
+
+	mp, err := mempool.New(mempool.Config{
+		SelectStrategy: "tip",
+		NonceFunc:      accts.Nonce,
+	})
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to construct the mempool: %s", failed, err)
+	}
+
+	s := State{
+		evHandler: func(v string, args ...interface{}) {},
+		genesis:   gen,
+		storage:   strg,
+		mempool:   mp,
+		accounts:  accts,
+		snapshots: &snapshots{},
+		tree:      newBlockTree(storage.Block{}),
+	}
+
+	return &s
+}
+
+// newBranch constructs a number of empty blocks mined by the specified miner
+// that build on the parent, with the state roots the accounts end up with.
+func newBranch(gen genesis.Genesis, accts *accounts.Accounts, parent storage.Block, miner storage.Account, count int) []storage.Block {
+	accts = accts.Clone()
+
+	var blocks []storage.Block
+	for i := 0; i < count; i++ {
+		accts.ApplyMiningReward(miner)
+		block := storage.NewBlock(miner, gen.Difficulty, gen.TransPerBlock, parent, nil, accts.StateRoot())
+		blocks = append(blocks, block)
+		parent = block
+	}
+
+	return blocks
+}
+
+func TestReorganize(t *testing.T) {
+	type table struct {
+		name      string
+		failB     int // Number of the new branch block that fails to write.
+		failA     int // Number of the old branch block that fails to be put back.
+		expErr    bool
+		expHead   storage.Account
+		expNumber uint64
+	}
+
+	tt := []table{
+		{name: "new branch is written", expHead: minerB, expNumber: 3},
+		{name: "new branch fails to write", failB: 2, expErr: true, expHead: minerA, expNumber: 2},
+		{name: "old branch fails to be put back", failB: 2, failA: 2, expErr: true, expHead: minerA, expNumber: 1},
+	}
+
+	t.Log("Given the need to switch to a branch with more work.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				strg := failStorage{Storage: memory.New(), fail: make(map[string]bool)}
+				s := newTestState(t, &strg)
+
+				branchA := newBranch(s.genesis, s.accounts, storage.Block{}, minerA, 2)
+				branchB := newBranch(s.genesis, s.accounts, storage.Block{}, minerB, 3)
+
+				for _, block := range branchA {
+					if err := s.updateLocalState(storage.BlockFS{Hash: block.Hash(), Block: block}); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to add the old branch: %s", failed, testID, err)
+					}
+				}
+
+				if test.failB > 0 {
+					strg.fail[branchB[test.failB-1].Hash()] = true
+				}
+				if test.failA > 0 {
+					strg.fail[branchA[test.failA-1].Hash()] = true
+				}
+
+				var err error
+				for _, block := range branchB {
+					if err = s.updateLocalState(storage.BlockFS{Hash: block.Hash(), Block: block}); err != nil {
+						break
+					}
+				}
+
+				if (err != nil) != test.expErr {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected error: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected error.", success, testID)
+
+				head := s.tree.head.block
+				if head.Header.MinerAccount != test.expHead || head.Header.Number != test.expNumber {
+					t.Fatalf("\t%s\tTest %d:\tShould have the expected head: got %s[%d], exp %s[%d]", failed, testID, head.Header.MinerAccount, head.Header.Number, test.expHead, test.expNumber)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the expected head.", success, testID)
+
+				var number uint64
+				iter := s.storage.ForEach()
+				for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+					if err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to read the blocks from storage: %s", failed, testID, err)
+					}
+					number = block.Header.Number
+
+					if exp := s.tree.branch(s.tree.root, s.tree.head)[number-1].hash; block.Hash() != exp {
+						t.Fatalf("\t%s\tTest %d:\tShould have the canonical block %d in storage: got %s, exp %s", failed, testID, number, block.Hash(), exp)
+					}
+				}
+				if number != head.Header.Number {
+					t.Fatalf("\t%s\tTest %d:\tShould have the head as the latest block in storage: got %d, exp %d", failed, testID, number, head.Header.Number)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have storage match the head.", success, testID)
+
+				if root := s.accounts.StateRoot(); root != head.Header.StateRoot {
+					t.Fatalf("\t%s\tTest %d:\tShould have the accounts match the head: got %s, exp %s", failed, testID, root, head.Header.StateRoot)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the accounts match the head.", success, testID)
+			}
+		}
+	}
+}
+
+func TestForkChoice(t *testing.T) {
+	type table struct {
+		name        string
+		blocksA     int // Blocks on the canonical branch.
+		difficultyA int
+		blocksB     int // Blocks on the competing branch.
+		difficultyB int
+		expHead     storage.Account
+		expNumber   uint64
+	}
+
+	tt := []table{
+		{name: "competing branch is longer", blocksA: 2, difficultyA: 1, blocksB: 3, difficultyB: 1, expHead: minerB, expNumber: 3},
+		{name: "competing branch has the same work", blocksA: 2, difficultyA: 1, blocksB: 2, difficultyB: 1, expHead: minerA, expNumber: 2},
+		{name: "competing branch is shorter with more work", blocksA: 3, difficultyA: 1, blocksB: 2, difficultyB: 3, expHead: minerB, expNumber: 2},
+		{name: "competing branch is longer with less work", blocksA: 2, difficultyA: 3, blocksB: 3, difficultyB: 1, expHead: minerA, expNumber: 2},
+	}
+
+	t.Log("Given the need to choose the branch with the most work.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				s := newTestState(t, memory.New())
+
+				genA := s.genesis
+				genA.Difficulty = test.difficultyA
+				branchA := newBranch(genA, s.accounts, storage.Block{}, minerA, test.blocksA)
+
+				genB := s.genesis
+				genB.Difficulty = test.difficultyB
+				branchB := newBranch(genB, s.accounts, storage.Block{}, minerB, test.blocksB)
+
+				for _, block := range append(branchA, branchB...) {
+					if err := s.updateLocalState(storage.BlockFS{Hash: block.Hash(), Block: block}); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to add block %d: %s", failed, testID, block.Header.Number, err)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to add both branches.", success, testID)
+
+				head := s.tree.head.block
+				if head.Header.MinerAccount != test.expHead || head.Header.Number != test.expNumber {
+					t.Fatalf("\t%s\tTest %d:\tShould have the expected head: got %s[%d], exp %s[%d]", failed, testID, head.Header.MinerAccount, head.Header.Number, test.expHead, test.expNumber)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the expected head.", success, testID)
+
+				expWork := new(big.Int).Mul(big.NewInt(int64(test.expNumber)), blockWork(head.Header.Difficulty))
+				if s.tree.head.work.Cmp(expWork) != 0 {
+					t.Fatalf("\t%s\tTest %d:\tShould have the work of the head: got %s, exp %s", failed, testID, s.tree.head.work, expWork)
+				}
+				t.Logf("\t%s\tTest %d:\tShould have the work of the head.", success, testID)
+
+				for _, block := range append(branchA, branchB...) {
+					if _, exists := s.tree.lookup(block.Hash()); !exists {
+						t.Fatalf("\t%s\tTest %d:\tShould keep block %d of the other branch in the tree.", failed, testID, block.Header.Number)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould keep the other branch in the tree.", success, testID)
+			}
+		}
+	}
+}
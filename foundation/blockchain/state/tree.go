@@ -0,0 +1,178 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// maxBranchDepth represents the number of blocks behind the head of the
+// chain that are kept in the block tree. A fork that branches off further
+// back than this can't be resolved.
+const maxBranchDepth = 100
+
+// errBlockExists is returned when a block is added to the block tree
+// more than once.
+var errBlockExists = errors.New("block already exists")
+
+// errUnknownParent is returned when a block is added to the block tree and
+// the block it builds on is not in the tree.
+var errUnknownParent = errors.New("parent block is unknown")
+
+// =============================================================================
+
+// node represents a block in the block tree.
+type node struct {
+	hash   string
+	block  storage.Block
+	parent *node
+	work   *big.Int // Total work performed on the chain ending with this block.
+}
+
+// blockTree maintains the most recent blocks of the canonical chain along
+// with any competing branches. The head of the tree is the block at the end
+// of the branch with the most accumulated work.
+type blockTree struct {
+	nodes map[string]*node
+	root  *node
+	head  *node
+}
+
+// newBlockTree constructs a block tree rooted at the specified block. An
+// empty block roots the tree at the genesis of the chain.
+func newBlockTree(block storage.Block) *blockTree {
+	root := node{
+		hash:  block.Hash(),
+		block: block,
+		work:  big.NewInt(0),
+	}
+
+	bt := blockTree{
+		nodes: map[string]*node{root.hash: &root},
+		root:  &root,
+		head:  &root,
+	}
+
+	return &bt
+}
+
+// lookup returns the node for the specified block hash.
+func (bt *blockTree) lookup(hash string) (*node, bool) {
+	nd, exists := bt.nodes[hash]
+	return nd, exists
+}
+
+// add inserts the block into the tree under its parent and calculates the
+// total work for the branch the block extends.
+func (bt *blockTree) add(hash string, block storage.Block) (*node, error) {
+	if _, exists := bt.nodes[hash]; exists {
+		return nil, errBlockExists
+	}
+
+	parent, exists := bt.nodes[block.Header.ParentHash]
+	if !exists {
+		return nil, errUnknownParent
+	}
+
+	if block.Header.Number != parent.block.Header.Number+1 {
+		return nil, fmt.Errorf("this block is not the next number, got %d, exp %d", block.Header.Number, parent.block.Header.Number+1)
+	}
+
+	nd := node{
+		hash:   hash,
+		block:  block,
+		parent: parent,
+		work:   new(big.Int).Add(parent.work, blockWork(block.Header.Difficulty)),
+	}
+	bt.nodes[hash] = &nd
+
+	return &nd, nil
+}
+
+// remove deletes the node and every node that builds on it from the tree.
+func (bt *blockTree) remove(nd *node) {
+	for hash, n := range bt.nodes {
+		for ; n != nil && n.block.Header.Number >= nd.block.Header.Number; n = n.parent {
+			if n == nd {
+				delete(bt.nodes, hash)
+				break
+			}
+		}
+	}
+}
+
+// ancestor returns the latest node the two nodes have in common.
+func (bt *blockTree) ancestor(a *node, b *node) *node {
+	for a != b {
+		switch {
+		case a.block.Header.Number > b.block.Header.Number:
+			a = a.parent
+		case b.block.Header.Number > a.block.Header.Number:
+			b = b.parent
+		default:
+			a = a.parent
+			b = b.parent
+		}
+	}
+
+	return a
+}
+
+// branch returns the nodes after the specified ancestor up to and including
+// the specified node, ordered by block number.
+func (bt *blockTree) branch(ancestor *node, nd *node) []*node {
+	var nodes []*node
+	for ; nd != ancestor; nd = nd.parent {
+		nodes = append(nodes, nd)
+	}
+
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+
+	return nodes
+}
+
+// setWork shifts the work of every node in the tree so the specified node
+// has the specified total work. The tree starts with no work at its root, so
+// this is needed when the root isn't the genesis of the chain.
+func (bt *blockTree) setWork(nd *node, work *big.Int) {
+	delta := new(big.Int).Sub(work, nd.work)
+	for _, n := range bt.nodes {
+		n.work = new(big.Int).Add(n.work, delta)
+	}
+}
+
+// prune moves the root of the tree up the canonical chain so only
+// maxBranchDepth blocks are kept behind the head. Branches that fork off
+// before the new root are removed.
+func (bt *blockTree) prune() {
+	if bt.head.block.Header.Number <= bt.root.block.Header.Number+maxBranchDepth {
+		return
+	}
+
+	root := bt.head
+	for root.block.Header.Number > bt.head.block.Header.Number-maxBranchDepth {
+		root = root.parent
+	}
+
+	for hash, nd := range bt.nodes {
+		if nd.block.Header.Number < root.block.Header.Number {
+			delete(bt.nodes, hash)
+			continue
+		}
+
+		ancestor := nd
+		for ancestor.block.Header.Number > root.block.Header.Number {
+			ancestor = ancestor.parent
+		}
+		if ancestor != root {
+			delete(bt.nodes, hash)
+		}
+	}
+
+	root.parent = nil
+	bt.root = root
+}
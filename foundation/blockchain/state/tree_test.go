@@ -0,0 +1,123 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// extend adds a number of empty blocks mined by the specified miner to the
+// tree, building on the parent.
+func extend(t *testing.T, bt *blockTree, parent *node, miner storage.Account, count int) []*node {
+	var nodes []*node
+	for i := 0; i < count; i++ {
+		block := storage.NewBlock(miner, 1, 2, parent.block, nil, "")
+
+		nd, err := bt.add(block.Hash(), block)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to add block %d to the tree: %s", failed, block.Header.Number, err)
+		}
+		nodes = append(nodes, nd)
+		parent = nd
+	}
+
+	return nodes
+}
+
+func TestBlockTree(t *testing.T) {
+	t.Log("Given the need to track competing branches in the block tree.")
+	{
+		t.Logf("\tTest 0:\tWhen a branch forks off block 2.")
+		{
+			bt := newBlockTree(storage.Block{})
+			chainA := extend(t, bt, bt.root, minerA, 5)
+			chainB := extend(t, bt, chainA[1], minerB, 3)
+			bt.head = chainA[4]
+
+			if _, err := bt.add(chainA[2].hash, chainA[2].block); err != errBlockExists {
+				t.Fatalf("\t%s\tTest 0:\tShould not be able to add a block twice: %v", failed, err)
+			}
+			missing := storage.NewBlock(minerB, 1, 2, chainB[2].block, nil, "")
+			orphan := storage.NewBlock(minerB, 1, 2, missing, nil, "")
+			if _, err := bt.add(orphan.Hash(), orphan); err != errUnknownParent {
+				t.Fatalf("\t%s\tTest 0:\tShould not be able to add a block with an unknown parent: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould only add new blocks with a known parent.", success)
+
+			if nd := bt.ancestor(chainA[4], chainB[2]); nd != chainA[1] {
+				t.Fatalf("\t%s\tTest 0:\tShould find block 2 as the common ancestor: got %d", failed, nd.block.Header.Number)
+			}
+			if nd := bt.ancestor(chainA[4], chainA[2]); nd != chainA[2] {
+				t.Fatalf("\t%s\tTest 0:\tShould find block 3 as the ancestor of block 5: got %d", failed, nd.block.Header.Number)
+			}
+			t.Logf("\t%s\tTest 0:\tShould find the common ancestor.", success)
+
+			branch := bt.branch(chainA[1], chainB[2])
+			if len(branch) != 3 {
+				t.Fatalf("\t%s\tTest 0:\tShould get the blocks after the ancestor: got %d, exp 3", failed, len(branch))
+			}
+			for i, nd := range branch {
+				if nd != chainB[i] {
+					t.Fatalf("\t%s\tTest 0:\tShould get the blocks in order: got %d at %d", failed, nd.block.Header.Number, i)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the blocks after the ancestor in order.", success)
+
+			exp := 1 + len(chainA) + len(chainB)
+			if len(bt.nodes) != exp {
+				t.Fatalf("\t%s\tTest 0:\tShould hold every block: got %d, exp %d", failed, len(bt.nodes), exp)
+			}
+
+			bt.remove(chainB[1])
+			for i, nd := range chainB {
+				if _, exists := bt.lookup(nd.hash); exists != (i == 0) {
+					t.Fatalf("\t%s\tTest 0:\tShould remove the block and the blocks building on it: block %d exists %v", failed, nd.block.Header.Number, exists)
+				}
+			}
+			if len(bt.nodes) != exp-2 {
+				t.Fatalf("\t%s\tTest 0:\tShould only remove the branch: got %d, exp %d", failed, len(bt.nodes), exp-2)
+			}
+			t.Logf("\t%s\tTest 0:\tShould remove a block and the blocks building on it.", success)
+		}
+
+		t.Logf("\tTest 1:\tWhen the head is more than %d blocks past the root.", maxBranchDepth)
+		{
+			bt := newBlockTree(storage.Block{})
+			chainA := extend(t, bt, bt.root, minerA, maxBranchDepth+5)
+			chainB := extend(t, bt, chainA[1], minerB, 3)
+			chainC := extend(t, bt, chainA[10], minerB, 3)
+
+			origin := bt.root
+			bt.head = chainA[maxBranchDepth-1]
+			bt.prune()
+			if bt.root != origin || len(bt.nodes) != 1+len(chainA)+len(chainB)+len(chainC) {
+				t.Fatalf("\t%s\tTest 1:\tShould keep the tree when the head is %d blocks past the root: root %d", failed, maxBranchDepth, bt.root.block.Header.Number)
+			}
+			t.Logf("\t%s\tTest 1:\tShould keep the tree when the head is %d blocks past the root.", success, maxBranchDepth)
+
+			bt.head = chainA[len(chainA)-1]
+			bt.prune()
+
+			root := chainA[len(chainA)-1-maxBranchDepth]
+			if bt.root != root || root.parent != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould move the root to block %d: got %d", failed, root.block.Header.Number, bt.root.block.Header.Number)
+			}
+			t.Logf("\t%s\tTest 1:\tShould move the root to block %d.", success, root.block.Header.Number)
+
+			for _, nd := range chainB {
+				if _, exists := bt.lookup(nd.hash); exists {
+					t.Fatalf("\t%s\tTest 1:\tShould remove the branch that forks off before the root: block %d", failed, nd.block.Header.Number)
+				}
+			}
+			for _, nd := range chainC {
+				if _, exists := bt.lookup(nd.hash); !exists {
+					t.Fatalf("\t%s\tTest 1:\tShould keep the branch that forks off after the root: block %d", failed, nd.block.Header.Number)
+				}
+			}
+			if exp := maxBranchDepth + 1 + len(chainC); len(bt.nodes) != exp {
+				t.Fatalf("\t%s\tTest 1:\tShould hold the blocks from the root on: got %d, exp %d", failed, len(bt.nodes), exp)
+			}
+			t.Logf("\t%s\tTest 1:\tShould only keep the blocks from the root on.", success)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // maxTxShareRequests represents the max number of pending tx network share
@@ -26,14 +27,28 @@ const maxTxShareRequests = 100
 // and updating the blockchain on disk with missing blocks.
 const peerUpdateInterval = time.Minute
 
+// mempoolExpireInterval represents the interval of removing transactions
+// from the mempool that have been waiting longer than the TTL.
+const mempoolExpireInterval = time.Minute
+
+// maxBlocksPerQuery represents the number of blocks requested from a peer
+// at a time while syncing.
+const maxBlocksPerQuery = 32
+
+// maxResponseBytes represents the size of the largest response body read
+// from a peer, which is enough to hold a full batch of blocks.
+const maxResponseBytes = maxBlocksPerQuery * storage.MaxBlockBytes
+
 // worker manages the POW workflows for the blockchain.
 type worker struct {
 	state        *State
 	wg           sync.WaitGroup
 	ticker       time.Ticker
+	expireTicker time.Ticker
 	shut         chan struct{}
 	startMining  chan bool
 	cancelMining chan chan struct{}
+	resolveFork  chan bool
 	txSharing    chan storage.BlockTx
 	evHandler    EventHandler
 	baseURL      string
@@ -47,9 +62,11 @@ func runWorker(state *State, evHandler EventHandler) {
 	state.worker = &worker{
 		state:        state,
 		ticker:       *time.NewTicker(peerUpdateInterval),
+		expireTicker: *time.NewTicker(mempoolExpireInterval),
 		shut:         make(chan struct{}),
 		startMining:  make(chan bool, 1),
 		cancelMining: make(chan chan struct{}, 1),
+		resolveFork:  make(chan bool, 1),
 		txSharing:    make(chan storage.BlockTx, maxTxShareRequests),
 		evHandler:    evHandler,
 		baseURL:      "http://%s/v1/node",
@@ -63,6 +80,8 @@ func runWorker(state *State, evHandler EventHandler) {
 		state.worker.peerOperations,
 		state.worker.miningOperations,
 		state.worker.shareTxOperations,
+		state.worker.forkOperations,
+		state.worker.expireOperations,
 	}
 
 	// Set waitgroup to match the number of G's we need for the set
@@ -86,6 +105,12 @@ func runWorker(state *State, evHandler EventHandler) {
 	for i := 0; i < g; i++ {
 		<-hasStarted
 	}
+
+	// Mine the transactions that were loaded into the mempool while the
+	// node was starting up.
+	if state.mempool.CountPending() >= state.genesis.TransPerBlock {
+		state.worker.signalStartMining()
+	}
 }
 
 // shutdown terminates the goroutine performing work.
@@ -95,6 +120,7 @@ func (w *worker) shutdown() {
 
 	w.evHandler("worker: shutdown: stop ticker")
 	w.ticker.Stop()
+	w.expireTicker.Stop()
 
 	w.evHandler("worker: shutdown: signal cancel mining")
 	done := w.signalCancelMining()
@@ -132,14 +158,37 @@ func (w *worker) sync() {
 		}
 		for _, tx := range pool {
 			w.evHandler("worker: sync: queryPeerMempool: %s: Add Tx: %s", peer.Host, tx.SignatureString()[:16])
-			w.state.mempool.Upsert(tx)
+			if _, err := w.state.SubmitNodeTransaction(tx); err != nil {
+				w.evHandler("worker: sync: queryPeerMempool: %s: Add Tx: ERROR: %s", peer.Host, err)
+			}
 		}
 
-		// If this peer has blocks we don't have, we need to add them.
-		if peerStatus.LatestBlockNumber > w.state.RetrieveLatestBlock().Header.Number {
-			w.evHandler("worker: sync: writePeerBlocks: %s: latestBlockNumber[%d]", peer.Host, peerStatus.LatestBlockNumber)
-			if err := w.retrievePeerBlocks(peer); err != nil {
-				w.evHandler("worker: sync: writePeerBlocks: %s: ERROR %s", peer.Host, err)
+		// If this peer's chain has more work than ours, we need its blocks.
+		// Difficulty changes between blocks, so a chain with more work isn't
+		// always longer. A peer that isn't ahead of us must be on a different
+		// branch of the chain.
+		if !w.state.isHeavierChain(peerStatus.LatestBlockWork) {
+			continue
+		}
+
+		if peerStatus.LatestBlockNumber <= w.state.RetrieveLatestBlock().Header.Number {
+			w.evHandler("worker: sync: resolvePeerFork: %s: latestBlockWork[%s]", peer.Host, peerStatus.LatestBlockWork)
+			if err := w.resolvePeerFork(peer); err != nil {
+				w.evHandler("worker: sync: resolvePeerFork: %s: ERROR %s", peer.Host, err)
+			}
+			continue
+		}
+
+		w.evHandler("worker: sync: writePeerBlocks: %s: latestBlockNumber[%d]", peer.Host, peerStatus.LatestBlockNumber)
+		if err := w.retrievePeerBlocks(peer); err != nil {
+			w.evHandler("worker: sync: writePeerBlocks: %s: ERROR %s", peer.Host, err)
+
+			// The peer is on a different branch of the chain, so we need
+			// to reorganize this node to the peer's chain.
+			if errors.Is(err, ErrChainForked) {
+				if err := w.resolvePeerFork(peer); err != nil {
+					w.evHandler("worker: sync: resolvePeerFork: %s: ERROR %s", peer.Host, err)
+				}
 			}
 		}
 	}
@@ -152,24 +201,105 @@ func (w *worker) retrievePeerBlocks(pr peer.Peer) error {
 	defer w.evHandler("worker: sync: retrievePeerBlocks: completed: %s", pr)
 
 	from := w.state.RetrieveLatestBlock().Header.Number + 1
-	url := fmt.Sprintf("%s/block/list/%d/latest", fmt.Sprintf(w.baseURL, pr.Host), from)
 
-	var blocks []storage.Block
-	if err := send(http.MethodGet, url, nil, &blocks); err != nil {
+	return w.writePeerBlocks(pr, from)
+}
+
+// resolvePeerFork finds the latest block this node has in common with the
+// specified peer and retrieves the peer's blocks after it. The blocks are
+// added to the block tree, which switches the chain to the peer's branch if
+// that branch has more work.
+func (w *worker) resolvePeerFork(pr peer.Peer) error {
+	w.evHandler("worker: resolvePeerFork: started: %s", pr)
+	defer w.evHandler("worker: resolvePeerFork: completed: %s", pr)
+
+	peerStatus, err := w.queryPeerStatus(pr)
+	if err != nil {
 		return err
 	}
 
-	w.evHandler("worker: sync: retrievePeerBlocks: found blocks[%d]", len(blocks))
+	// If we already have the peer's latest block, there is nothing to do.
+	if w.state.isKnownBlock(peerStatus.LatestBlockHash) {
+		w.evHandler("worker: resolvePeerFork: peer latest block is known: %s", peerStatus.LatestBlockHash)
+		return nil
+	}
 
-	for _, block := range blocks {
-		w.evHandler("worker: sync: retrievePeerBlocks: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.ParentHash, block.Hash(), len(block.Transactions))
+	// Walk back from the peer's blocks a range at a time until we find one
+	// we have. Only the blocks held in the block tree can be compared, so
+	// the walk stops at the root of the tree.
+	number := w.state.RetrieveLatestBlock().Header.Number
+	if peerStatus.LatestBlockNumber < number {
+		number = peerStatus.LatestBlockNumber
+	}
+
+	root := w.state.rootNumber()
+	first := root
+	if first == 0 {
+		first = 1
+	}
 
-		if err := w.state.MinePeerBlock(block); err != nil {
+	var found bool
+	for to := number; to >= first && !found; {
+		from := first
+		if to >= first+maxBlocksPerQuery {
+			from = to - maxBlocksPerQuery + 1
+		}
+
+		blocks, err := w.queryPeerBlocks(pr, from, to)
+		if err != nil {
 			return err
 		}
+
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if w.state.isKnownBlock(blocks[i].Hash()) {
+				number = blocks[i].Header.Number
+				found = true
+				break
+			}
+		}
+
+		to = from - 1
 	}
 
-	return nil
+	// The chains only have the genesis in common, which is only in the
+	// block tree if it hasn't been pruned.
+	if !found {
+		if root > 0 {
+			return errors.New("no common block found, fork is too deep to resolve")
+		}
+		number = 0
+	}
+
+	w.evHandler("worker: resolvePeerFork: common ancestor: block[%d]", number)
+
+	return w.writePeerBlocks(pr, number+1)
+}
+
+// writePeerBlocks retrieves the peer's blocks starting with the specified
+// number and adds them to the chain. The blocks are retrieved in batches so
+// each response from the peer stays small.
+func (w *worker) writePeerBlocks(pr peer.Peer, from uint64) error {
+	for {
+		blocks, err := w.queryPeerBlocks(pr, from, from+maxBlocksPerQuery-1)
+		if err != nil {
+			return err
+		}
+
+		w.evHandler("worker: sync: writePeerBlocks: found blocks[%d]", len(blocks))
+
+		for _, block := range blocks {
+			w.evHandler("worker: sync: writePeerBlocks: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.ParentHash, block.Hash(), len(block.Transactions))
+
+			if err := w.state.MinePeerBlock(block); err != nil && !errors.Is(err, errBlockExists) {
+				return err
+			}
+		}
+
+		if len(blocks) < maxBlocksPerQuery {
+			return nil
+		}
+		from += maxBlocksPerQuery
+	}
 }
 
 // =============================================================================
@@ -209,6 +339,21 @@ func (w *worker) queryPeerMempool(pr peer.Peer) ([]storage.BlockTx, error) {
 	return mempool, nil
 }
 
+// queryPeerBlocks asks the peer for the blocks in the specified range.
+func (w *worker) queryPeerBlocks(pr peer.Peer, from uint64, to uint64) ([]storage.Block, error) {
+	w.evHandler("worker: queryPeerBlocks: started: %s: from[%d]: to[%d]", pr, from, to)
+	defer w.evHandler("worker: queryPeerBlocks: completed: %s", pr)
+
+	url := fmt.Sprintf("%s/block/list/%d/%d", fmt.Sprintf(w.baseURL, pr.Host), from, to)
+
+	var blocks []storage.Block
+	if err := send(http.MethodGet, url, nil, &blocks); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
 // addNewPeers takes the list of known peers and makes sure they are included
 // in the nodes list of know peers.
 func (w *worker) addNewPeers(knownPeers []peer.Peer) error {
@@ -283,6 +428,42 @@ func (w *worker) miningOperations() {
 	}
 }
 
+// forkOperations handles resolving forks with peers.
+func (w *worker) forkOperations() {
+	w.evHandler("worker: forkOperations: G started")
+	defer w.evHandler("worker: forkOperations: G completed")
+
+	for {
+		select {
+		case <-w.resolveFork:
+			if !w.isShutdown() {
+				w.runResolveForkOperation()
+			}
+		case <-w.shut:
+			w.evHandler("worker: forkOperations: received shut signal")
+			return
+		}
+	}
+}
+
+// expireOperations handles removing expired transactions from the mempool.
+func (w *worker) expireOperations() {
+	w.evHandler("worker: expireOperations: G started")
+	defer w.evHandler("worker: expireOperations: G completed")
+
+	for {
+		select {
+		case <-w.expireTicker.C:
+			if !w.isShutdown() {
+				w.runExpireOperation()
+			}
+		case <-w.shut:
+			w.evHandler("worker: expireOperations: received shut signal")
+			return
+		}
+	}
+}
+
 // isShutdown is used to test if a shutdown has been signaled.
 func (w *worker) isShutdown() bool {
 	select {
@@ -316,6 +497,16 @@ func (w *worker) signalShareTransactions(blockTx storage.BlockTx) {
 	}
 }
 
+// signalResolveFork starts a fork resolution operation. If there is already
+// a signal pending in the channel, just return since an operation will start.
+func (w *worker) signalResolveFork() {
+	select {
+	case w.resolveFork <- true:
+	default:
+	}
+	w.evHandler("worker: signalResolveFork: fork resolution signaled")
+}
+
 // signalCancelMining signals the G executing the runMiningOperation function
 // to stop immediately. That G will not return from the function until done
 // is called. This allows the caller to complete any state changes before a new
@@ -354,14 +545,40 @@ func (w *worker) runFindNewPeersOperation() {
 	}
 }
 
+// runResolveForkOperation checks every known peer for a longer chain and
+// reorganizes this node's chain to match.
+func (w *worker) runResolveForkOperation() {
+	w.evHandler("worker: runResolveForkOperation: started")
+	defer w.evHandler("worker: runResolveForkOperation: completed")
+
+	for _, peer := range w.state.RetrieveKnownPeers() {
+		if err := w.resolvePeerFork(peer); err != nil {
+			w.evHandler("worker: runResolveForkOperation: resolvePeerFork: %s: ERROR: %s", peer.Host, err)
+		}
+	}
+}
+
+// runExpireOperation removes the transactions from the mempool that have been
+// waiting longer than the TTL. The mempool journal is compacted at the same
+// time.
+func (w *worker) runExpireOperation() {
+	if n := w.state.mempool.Expire(); n > 0 {
+		w.evHandler("worker: runExpireOperation: removed %d expired transactions", n)
+	}
+
+	if err := w.state.mempool.Rotate(); err != nil {
+		w.evHandler("worker: runExpireOperation: rotate mempool journal: ERROR: %s", err)
+	}
+}
+
 // runMiningOperation takes all the transactions from the mempool and writes a
 // new block to the database.
 func (w *worker) runMiningOperation() {
 	w.evHandler("worker: runMiningOperation: MINING: started")
 	defer w.evHandler("worker: runMiningOperation: MINING: completed")
 
-	// Make sure there are at least transPerBlock in the mempool.
-	length := w.state.QueryMempoolLength()
+	// Make sure there are at least transPerBlock ready to mine in the mempool.
+	length := w.state.mempool.CountPending()
 	if length < w.state.genesis.TransPerBlock {
 		w.evHandler("worker: runMiningOperation: MINING: not enough transactions to mine: Txs[%d]", length)
 		return
@@ -370,7 +587,7 @@ func (w *worker) runMiningOperation() {
 	// After running a mining operation, check if a new operation should
 	// be signaled again.
 	defer func() {
-		length := w.state.QueryMempoolLength()
+		length := w.state.mempool.CountPending()
 		if length >= w.state.genesis.TransPerBlock {
 			w.evHandler("worker: runMiningOperation: MINING: signal new mining operation: Txs[%d]", length)
 			w.signalStartMining()
@@ -490,13 +707,17 @@ func (w *worker) runShareTxOperation(tx storage.BlockTx) {
 
 // =============================================================================
 
-// send is a helper function to send an HTTP request to a node.
+// send is a helper function to send an HTTP request to a node. Blocks and
+// transactions are sent as RLP, and the node is asked to respond with RLP.
+// The response is decoded based on the content type the node picked and is
+// limited in size so a peer can't make the node read an unbounded amount of
+// data.
 func send(method string, url string, dataSend interface{}, dataRecv interface{}) error {
 	var req *http.Request
 
 	switch {
 	case dataSend != nil:
-		data, err := json.Marshal(dataSend)
+		data, err := rlp.EncodeToBytes(dataSend)
 		if err != nil {
 			return err
 		}
@@ -504,6 +725,7 @@ func send(method string, url string, dataSend interface{}, dataRecv interface{})
 		if err != nil {
 			return err
 		}
+		req.Header.Set("Content-Type", storage.ContentTypeRLP)
 
 	default:
 		var err error
@@ -513,6 +735,8 @@ func send(method string, url string, dataSend interface{}, dataRecv interface{})
 		}
 	}
 
+	req.Header.Set("Accept", storage.ContentTypeRLP+", "+storage.ContentTypeJSON)
+
 	var client http.Client
 	resp, err := client.Do(req)
 	if err != nil {
@@ -520,12 +744,14 @@ func send(method string, url string, dataSend interface{}, dataRecv interface{})
 	}
 	defer resp.Body.Close()
 
+	body := io.LimitReader(resp.Body, maxResponseBytes)
+
 	if resp.StatusCode == http.StatusNoContent {
 		return nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		msg, err := io.ReadAll(resp.Body)
+		msg, err := io.ReadAll(body)
 		if err != nil {
 			return err
 		}
@@ -533,7 +759,11 @@ func send(method string, url string, dataSend interface{}, dataRecv interface{})
 	}
 
 	if dataRecv != nil {
-		if err := json.NewDecoder(resp.Body).Decode(dataRecv); err != nil {
+		if resp.Header.Get("Content-Type") == storage.ContentTypeRLP {
+			return rlp.NewStream(body, maxResponseBytes).Decode(dataRecv)
+		}
+
+		if err := json.NewDecoder(body).Decode(dataRecv); err != nil {
 			return err
 		}
 	}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"encoding/hex"
 	"time"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 )
 
@@ -10,11 +12,13 @@ import (
 type BlockHeader struct {
 	ParentHash   string  `json:"parent_hash"`   // Hash of the previous block in the chain.
 	MinerAccount Account `json:"miner_account"` // The account of the miner who mined the block.
-	Difficulty   int     `json:"difficulty"`    // Number of 0's needed to solve the hash solution.
+	Difficulty   int     `json:"difficulty"`    // Number of leading zero bits needed to solve the hash solution.
 	Number       uint64  `json:"number"`        // Block number in the chain.
 	TotalTip     uint    `json:"total_tip"`     // Total tip paid by all senders as an incentive.
 	TotalGas     uint    `json:"total_gas"`     // Total gas fee to recover computation costs paid by the sender.
 	TimeStamp    uint64  `json:"timestamp"`     // Time the block was mined.
+	TransRoot    string  `json:"trans_root"`    // Merkle root of the hashes of the transactions in the block.
+	StateRoot    string  `json:"state_root"`    // Merkle root of the account information after the block is applied.
 	Nonce        uint64  `json:"nonce"`         // Value identified to solve the hash solution.
 }
 
@@ -25,7 +29,7 @@ type Block struct {
 }
 
 // NewBlock constructs a new BlockFS for persisting.
-func NewBlock(minerAccount Account, difficulty int, transPerBlock int, parentBlock Block, trans []BlockTx) Block {
+func NewBlock(minerAccount Account, difficulty int, transPerBlock int, parentBlock Block, trans []BlockTx, stateRoot string) Block {
 	parentHash := signature.ZeroHash
 	if parentBlock.Header.Number > 0 {
 		parentHash = parentBlock.Hash()
@@ -47,6 +51,8 @@ func NewBlock(minerAccount Account, difficulty int, transPerBlock int, parentBlo
 			TotalTip:     totalTip,
 			TotalGas:     totalGas,
 			TimeStamp:    uint64(time.Now().UTC().Unix()),
+			TransRoot:    TransRoot(trans),
+			StateRoot:    stateRoot,
 		},
 		Transactions: trans,
 	}
@@ -54,13 +60,56 @@ func NewBlock(minerAccount Account, difficulty int, transPerBlock int, parentBlo
 	return nb
 }
 
-// Hash returns the unique hash for the Block.
+// Hash returns the unique hash for the Block. Only the header is hashed
+// since the transactions are committed to by the transaction root.
 func (b Block) Hash() string {
 	if b.Header.Number == 0 {
 		return signature.ZeroHash
 	}
 
-	return signature.Hash(b)
+	return signature.Hash(b.Header)
+}
+
+// HashBytes returns the unique hash for the Block as raw bytes. This avoids
+// the cost of hex encoding when the hash is only being checked.
+func (b Block) HashBytes() []byte {
+	return signature.HashBytes(b.Header)
+}
+
+// =============================================================================
+
+// TransRoot calculates the merkle root of the hashes of the transactions.
+func TransRoot(trans []BlockTx) string {
+	return hex.EncodeToString(merkle.Root(transLeaves(trans)))
+}
+
+// TransProof returns the merkle proof that the transaction at the specified
+// index is included in the set of transactions.
+func TransProof(trans []BlockTx, index int) ([]merkle.Step, error) {
+	return merkle.Proof(transLeaves(trans), index)
+}
+
+// VerifyTransProof checks the merkle proof shows the transaction is included
+// in a block with the specified transaction root. Wallets can use this to
+// confirm a transaction was mined using only the block header.
+func VerifyTransProof(tx BlockTx, proof []merkle.Step, transRoot string) bool {
+	root, err := hex.DecodeString(transRoot)
+	if err != nil {
+		return false
+	}
+
+	return merkle.Verify(tx.HashBytes(), proof, root)
+}
+
+// transLeaves returns the hashes of the transactions to use as the leaves
+// of a merkle tree.
+func transLeaves(trans []BlockTx) [][]byte {
+	leaves := make([][]byte, len(trans))
+	for i, tx := range trans {
+		leaves[i] = tx.HashBytes()
+	}
+
+	return leaves
 }
 
 // =============================================================================
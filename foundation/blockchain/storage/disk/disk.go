@@ -0,0 +1,312 @@
+// Package disk implements the storage interface using a file on disk. Each
+// block is encoded as JSON or RLP and written as a record framed with its
+// length and a CRC32 checksum, so a block torn by a crash can be detected and
+// dropped. Blocks are read back in either encoding, so a database file can be
+// switched from one encoding to the other without being rewritten.
+package disk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// EventHandler defines a function that is called when the database file
+// needs to be repaired.
+type EventHandler func(v string, args ...interface{})
+
+// Disk manages reading and writing of blocks to a file on disk. An index
+// kept next to the file provides lookups without reading the whole chain.
+type Disk struct {
+	dbPath   string
+	dbFile   *os.File
+	encoding string
+	index    *index
+	mu       sync.RWMutex
+}
+
+// New provides access to blockchain storage in the specified file, writing
+// new blocks with the specified encoding. A partially written block left
+// behind by a crash is removed. A file using the older format of one JSON
+// block per line is rejected, since those blocks were hashed differently and
+// the chain needs to be synced again from peers.
+func New(dbPath string, encoding string, evHandler EventHandler) (*Disk, error) {
+	switch encoding {
+	case storage.EncodingJSON, storage.EncodingRLP:
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+
+	// Build a safe event handler function for use.
+	ev := func(v string, args ...interface{}) {
+		if evHandler != nil {
+			evHandler(v, args...)
+		}
+	}
+
+	// Reject a database file using one JSON block per line.
+	if err := checkFormat(dbPath); err != nil {
+		return nil, err
+	}
+
+	// Open the blockchain database file with append.
+	dbFile, err := os.OpenFile(dbPath, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop a partially written block from the end of the file. Blocks are
+	// added to the index only after they are durably written, so a block
+	// recorded in the index can't be torn.
+	if err := repair(dbFile, committed(dbPath+".idx"), ev); err != nil {
+		dbFile.Close()
+		return nil, fmt.Errorf("repair: %w", err)
+	}
+
+	// Load the index for the database, rebuilding it if needed.
+	idx, err := openIndex(dbPath+".idx", dbFile)
+	if err != nil {
+		dbFile.Close()
+		return nil, fmt.Errorf("index: %w", err)
+	}
+
+	dsk := Disk{
+		dbPath:   dbPath,
+		dbFile:   dbFile,
+		encoding: encoding,
+		index:    idx,
+	}
+
+	return &dsk, nil
+}
+
+// Close cleanly releases the storage area.
+func (d *Disk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.index.close()
+	return d.dbFile.Close()
+}
+
+// Write adds a new block to the chain.
+func (d *Disk) Write(blockFS storage.BlockFS) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Marshal the block for writing to disk.
+	data, err := encodeBlock(blockFS, d.encoding)
+	if err != nil {
+		return err
+	}
+
+	offset := d.index.next()
+
+	// Write the new block to the chain on disk and make sure it's durable
+	// before it's added to the index. If the write fails, remove whatever
+	// part of the block made it to the file so the next block isn't
+	// written behind a torn one.
+	if _, err := d.dbFile.Write(encodeRecord(data)); err != nil {
+		d.dbFile.Truncate(offset)
+		return err
+	}
+	if err := d.dbFile.Sync(); err != nil {
+		d.dbFile.Truncate(offset)
+		return err
+	}
+
+	// Record where the block was written in the index. If that fails, the
+	// block is removed so the file and the index still agree.
+	if err := d.index.add(newEntry(blockFS, offset, int64(len(data)))); err != nil {
+		d.dbFile.Truncate(offset)
+		return err
+	}
+
+	return nil
+}
+
+// GetBlock returns the block with the specified number.
+func (d *Disk) GetBlock(number uint64) (storage.Block, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.read(number)
+}
+
+// GetBlockByHash returns the block with the specified hash.
+func (d *Disk) GetBlockByHash(hash string) (storage.Block, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	number, exists := d.index.hashes[hash]
+	if !exists {
+		return storage.Block{}, storage.ErrNotFound
+	}
+
+	return d.read(number)
+}
+
+// GetBlockByTransaction returns the block that includes the transaction with
+// the specified signature, along with the index of the transaction in the
+// block.
+func (d *Disk) GetBlockByTransaction(sig string) (storage.Block, int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	loc, exists := d.index.trans[sig]
+	if !exists {
+		return storage.Block{}, 0, storage.ErrNotFound
+	}
+
+	block, err := d.read(loc.number)
+	if err != nil {
+		return storage.Block{}, 0, err
+	}
+
+	return block, loc.index, nil
+}
+
+// GetBlocksByAccount returns the blocks with transactions sent or received
+// by the specified account.
+func (d *Disk) GetBlocksByAccount(account storage.Account) ([]storage.Block, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	numbers := d.index.accounts[account]
+
+	blocks := make([]storage.Block, 0, len(numbers))
+	for _, number := range numbers {
+		block, err := d.read(number)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// ForEach returns an iterator that streams the blocks in the chain in order
+// from the database file. The iterator stops at the end of the file as it
+// was when the iterator was created.
+func (d *Disk) ForEach() storage.Iterator {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	records, err := newRecordReader(d.dbFile)
+	return &iterator{records: records, err: err}
+}
+
+// Reset rolls the chain back so the block with the specified number becomes
+// the latest block. Passing 0 removes every block from storage.
+func (d *Disk) Reset(number uint64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Find where the block after the one we are keeping starts.
+	e, exists := d.index.lookup(number + 1)
+	if !exists {
+		return nil
+	}
+
+	if err := d.dbFile.Truncate(e.Offset); err != nil {
+		return err
+	}
+	if err := d.dbFile.Sync(); err != nil {
+		return err
+	}
+
+	return d.index.truncate(number)
+}
+
+// =============================================================================
+
+// read returns the block with the specified number using the index to find
+// it in the database file. The caller must hold the lock.
+func (d *Disk) read(number uint64) (storage.Block, error) {
+	e, exists := d.index.lookup(number)
+	if !exists {
+		return storage.Block{}, storage.ErrNotFound
+	}
+
+	record := make([]byte, recordHeaderSize+e.Size)
+	if _, err := d.dbFile.ReadAt(record, e.Offset); err != nil {
+		return storage.Block{}, err
+	}
+
+	data, err := decodeRecord(record)
+	if err != nil {
+		return storage.Block{}, fmt.Errorf("block %d: %w", number, err)
+	}
+
+	blockFS, err := decodeBlock(data)
+	if err != nil {
+		return storage.Block{}, fmt.Errorf("block %d: %w", number, err)
+	}
+
+	if blockFS.Hash != e.Hash || changed(blockFS) {
+		return storage.Block{}, fmt.Errorf("block %d has been changed", number)
+	}
+
+	return blockFS.Block, nil
+}
+
+// repair truncates the database file to remove a record at the end of the
+// file that was only partially written when the node stopped. Only a record
+// starting at or after the committed offset can be torn, any other bad
+// record means the file is corrupted.
+func repair(dbFile *os.File, committed int64, ev EventHandler) error {
+	info, err := dbFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	reader := bufio.NewReader(io.NewSectionReader(dbFile, 0, info.Size()))
+	for {
+		data, err := readRecord(reader, offset, info.Size())
+		switch {
+		case err == nil:
+			offset += recordHeaderSize + int64(len(data))
+			continue
+
+		case errors.Is(err, io.EOF):
+			return nil
+
+		case errors.Is(err, errTornRecord):
+			if offset < committed {
+				return fmt.Errorf("block recorded in the index is corrupted: %w", err)
+			}
+			ev("disk: repair: %s: dropping %d bytes from the end of the database", err, info.Size()-offset)
+			if err := dbFile.Truncate(offset); err != nil {
+				return err
+			}
+			return dbFile.Sync()
+		}
+
+		return err
+	}
+}
+
+// checkFormat returns an error if the database file holds one JSON block
+// per line. A record starts with the length of the block, so the first byte
+// of a record can't be the opening brace of a JSON block.
+func checkFormat(dbPath string) error {
+	dbFile, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer dbFile.Close()
+
+	first := make([]byte, 1)
+	if _, err := dbFile.Read(first); err != nil || first[0] != '{' {
+		return nil
+	}
+
+	return fmt.Errorf("%s uses an older format that can't be converted, remove it to sync the chain from peers", dbPath)
+}
@@ -0,0 +1,379 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// Accounts that mine the blocks and receive the transactions.
+const (
+	miner = storage.Account("0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9")
+	to    = storage.Account("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+)
+
+// newChain constructs a chain with the specified number of blocks, each
+// holding a single transaction sent by the returned account.
+func newChain(t *testing.T, count int) ([]storage.BlockFS, storage.Account) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to generate a private key: %s", failed, err)
+	}
+
+	var chain []storage.BlockFS
+	var parent storage.Block
+	for i := 0; i < count; i++ {
+		userTx, err := storage.NewUserTx(1, uint(i+1), to, 10, 0, nil)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct a transaction: %s", failed, err)
+		}
+
+		tx, err := userTx.Sign(privateKey)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to sign a transaction: %s", failed, err)
+		}
+
+		block := storage.NewBlock(miner, 1, 2, parent, []storage.BlockTx{storage.NewBlockTx(tx, 15)}, "")
+		chain = append(chain, storage.BlockFS{Hash: block.Hash(), Block: block})
+		parent = block
+	}
+
+	return chain, storage.PublicKeyToAccount(privateKey.PublicKey)
+}
+
+// newDisk creates an empty database file in a temporary directory and writes
+// the chain to it.
+func newDisk(t *testing.T, encoding string, chain []storage.BlockFS) (*Disk, string) {
+	dbPath := filepath.Join(t.TempDir(), "blocks.db")
+	if err := os.WriteFile(dbPath, nil, 0600); err != nil {
+		t.Fatalf("\t%s\tShould be able to create the database file: %s", failed, err)
+	}
+
+	dsk, err := New(dbPath, encoding, nil)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to open the database: %s", failed, err)
+	}
+
+	for _, blockFS := range chain {
+		if err := dsk.Write(blockFS); err != nil {
+			t.Fatalf("\t%s\tShould be able to write block %d: %s", failed, blockFS.Block.Header.Number, err)
+		}
+	}
+
+	return dsk, dbPath
+}
+
+// checkChain verifies every block in the chain can be found by number, hash
+// and transaction, and that the database has no other blocks.
+func checkChain(t *testing.T, testID int, dsk *Disk, chain []storage.BlockFS, from storage.Account) {
+	for _, blockFS := range chain {
+		number := blockFS.Block.Header.Number
+
+		block, err := dsk.GetBlock(number)
+		if err != nil || block.Hash() != blockFS.Hash {
+			t.Fatalf("\t%s\tTest %d:\tShould get block %d by number: %v", failed, testID, number, err)
+		}
+
+		block, err = dsk.GetBlockByHash(blockFS.Hash)
+		if err != nil || block.Header.Number != number {
+			t.Fatalf("\t%s\tTest %d:\tShould get block %d by hash: %v", failed, testID, number, err)
+		}
+
+		sig := blockFS.Block.Transactions[0].SignatureString()
+		block, index, err := dsk.GetBlockByTransaction(sig)
+		if err != nil || block.Header.Number != number || index != 0 {
+			t.Fatalf("\t%s\tTest %d:\tShould get block %d by transaction: %v", failed, testID, number, err)
+		}
+	}
+	t.Logf("\t%s\tTest %d:\tShould get every block by number, hash and transaction.", success, testID)
+
+	if _, err := dsk.GetBlock(uint64(len(chain) + 1)); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("\t%s\tTest %d:\tShould not find a block after the chain: %v", failed, testID, err)
+	}
+	t.Logf("\t%s\tTest %d:\tShould not find a block after the chain.", success, testID)
+
+	for _, account := range []storage.Account{from, to} {
+		blocks, err := dsk.GetBlocksByAccount(account)
+		if err != nil || len(blocks) != len(chain) {
+			t.Fatalf("\t%s\tTest %d:\tShould get every block for account %s: got %d, exp %d: %v", failed, testID, account, len(blocks), len(chain), err)
+		}
+	}
+	if blocks, err := dsk.GetBlocksByAccount(miner); err != nil || len(blocks) != 0 {
+		t.Fatalf("\t%s\tTest %d:\tShould get no blocks for the miner: got %d: %v", failed, testID, len(blocks), err)
+	}
+	t.Logf("\t%s\tTest %d:\tShould get the blocks by account.", success, testID)
+
+	var number uint64
+	iter := dsk.ForEach()
+	for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+		if err != nil {
+			t.Fatalf("\t%s\tTest %d:\tShould be able to iterate over the blocks: %s", failed, testID, err)
+		}
+		number++
+		if block.Hash() != chain[number-1].Hash {
+			t.Fatalf("\t%s\tTest %d:\tShould iterate over the blocks in order: got %d", failed, testID, block.Header.Number)
+		}
+	}
+	if number != uint64(len(chain)) {
+		t.Fatalf("\t%s\tTest %d:\tShould iterate over every block: got %d, exp %d", failed, testID, number, len(chain))
+	}
+	t.Logf("\t%s\tTest %d:\tShould iterate over every block in order.", success, testID)
+}
+
+func TestIndex(t *testing.T) {
+	type table struct {
+		name     string
+		encoding string
+		removed  bool // Remove the index file before opening the database again.
+	}
+
+	tt := []table{
+		{name: "reopening a JSON database", encoding: storage.EncodingJSON},
+		{name: "reopening an RLP database", encoding: storage.EncodingRLP},
+		{name: "reopening a database without an index", encoding: storage.EncodingRLP, removed: true},
+	}
+
+	const blocks = 5
+	chain, from := newChain(t, blocks)
+
+	t.Log("Given the need to look up blocks using the index.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen %s.", testID, test.name)
+			{
+				dsk, dbPath := newDisk(t, test.encoding, chain)
+				checkChain(t, testID, dsk, chain, from)
+				dsk.Close()
+
+				if test.removed {
+					if err := os.Remove(dbPath + ".idx"); err != nil {
+						t.Fatalf("\t%s\tTest %d:\tShould be able to remove the index: %s", failed, testID, err)
+					}
+				}
+
+				dsk, err := New(dbPath, test.encoding, nil)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to open the database again: %s", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to open the database again.", success, testID)
+
+				checkChain(t, testID, dsk, chain, from)
+				dsk.Close()
+			}
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	const blocks = 5
+	chain, from := newChain(t, blocks)
+
+	t.Log("Given the need to roll back the chain on disk.")
+	{
+		t.Logf("\tTest 0:\tWhen resetting the chain to block 2.")
+		{
+			dsk, dbPath := newDisk(t, storage.EncodingRLP, chain)
+
+			if err := dsk.Reset(2); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to reset the chain: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to reset the chain.", success)
+
+			checkChain(t, 0, dsk, chain[:2], from)
+
+			if _, err := dsk.GetBlockByHash(chain[2].Hash); !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("\t%s\tTest 0:\tShould remove the hash of block 3: %v", failed, err)
+			}
+			if _, _, err := dsk.GetBlockByTransaction(chain[2].Block.Transactions[0].SignatureString()); !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("\t%s\tTest 0:\tShould remove the transaction in block 3: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould remove the blocks after block 2 from the index.", success)
+
+			for _, blockFS := range chain[2:] {
+				if err := dsk.Write(blockFS); err != nil {
+					t.Fatalf("\t%s\tTest 0:\tShould be able to write block %d again: %s", failed, blockFS.Block.Header.Number, err)
+				}
+			}
+			checkChain(t, 0, dsk, chain, from)
+
+			dsk.Close()
+
+			dsk, err := New(dbPath, storage.EncodingRLP, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to open the database again: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to open the database again.", success)
+
+			checkChain(t, 0, dsk, chain, from)
+			dsk.Close()
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	type table struct {
+		name    string
+		damage  func(dbFile *os.File, dsk *Disk, next []byte) error
+		success bool
+	}
+
+	tt := []table{
+		{
+			name: "block is partially written",
+			damage: func(dbFile *os.File, dsk *Disk, next []byte) error {
+				_, err := dbFile.Write(next[:len(next)/2])
+				return err
+			},
+			success: true,
+		},
+		{
+			name: "block is written with a bad checksum",
+			damage: func(dbFile *os.File, dsk *Disk, next []byte) error {
+				next[4] ^= 0xff
+				_, err := dbFile.Write(next)
+				return err
+			},
+			success: true,
+		},
+		{
+			name: "checksum of the last indexed block is changed",
+			damage: func(dbFile *os.File, dsk *Disk, next []byte) error {
+				return flipChecksum(dbFile, dsk, 5)
+			},
+		},
+		{
+			name: "checksum of a block in the middle is changed",
+			damage: func(dbFile *os.File, dsk *Disk, next []byte) error {
+				return flipChecksum(dbFile, dsk, 3)
+			},
+		},
+	}
+
+	const blocks = 5
+	chain, from := newChain(t, blocks+1)
+
+	t.Log("Given the need to recover from a block torn by a crash.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen the %s.", testID, test.name)
+			{
+				dsk, dbPath := newDisk(t, storage.EncodingRLP, chain[:blocks])
+
+				data, err := encodeBlock(chain[blocks], storage.EncodingRLP)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to encode the next block: %s", failed, testID, err)
+				}
+
+				if err := test.damage(dsk.dbFile, dsk, encodeRecord(data)); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to damage the database: %s", failed, testID, err)
+				}
+				size := dsk.index.next()
+				dsk.Close()
+
+				var repaired bool
+				ev := func(v string, args ...interface{}) { repaired = true }
+
+				dsk, err = New(dbPath, storage.EncodingRLP, ev)
+				if !test.success {
+					if err == nil {
+						dsk.Close()
+						t.Fatalf("\t%s\tTest %d:\tShould not be able to open a corrupted database.", failed, testID)
+					}
+					t.Logf("\t%s\tTest %d:\tShould not be able to open a corrupted database: %s", success, testID, err)
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to open the database: %s", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to open the database.", success, testID)
+
+				info, err := os.Stat(dbPath)
+				if err != nil || info.Size() != size || !repaired {
+					t.Fatalf("\t%s\tTest %d:\tShould drop the torn block from the end of the file: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould drop the torn block from the end of the file.", success, testID)
+
+				checkChain(t, testID, dsk, chain[:blocks], from)
+
+				if err := dsk.Write(chain[blocks]); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to write the next block: %s", failed, testID, err)
+				}
+				checkChain(t, testID, dsk, chain, from)
+				dsk.Close()
+			}
+		}
+	}
+}
+
+func TestWriteIndexFails(t *testing.T) {
+	const blocks = 3
+	chain, from := newChain(t, blocks+1)
+
+	t.Log("Given the need to keep the database and index in agreement.")
+	{
+		t.Logf("\tTest 0:\tWhen a block can't be added to the index.")
+		{
+			dsk, dbPath := newDisk(t, storage.EncodingRLP, chain[:blocks])
+			size := dsk.index.next()
+
+			// Closing the index file makes adding the entry fail.
+			dsk.index.file.Close()
+
+			if err := dsk.Write(chain[blocks]); err == nil {
+				t.Fatalf("\t%s\tTest 0:\tShould not be able to write the block.", failed)
+			}
+			t.Logf("\t%s\tTest 0:\tShould not be able to write the block.", success)
+
+			info, err := os.Stat(dbPath)
+			if err != nil || info.Size() != size {
+				t.Fatalf("\t%s\tTest 0:\tShould remove the block from the database file: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould remove the block from the database file.", success)
+
+			dsk.dbFile.Close()
+
+			dsk, err = New(dbPath, storage.EncodingRLP, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to open the database again: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to open the database again.", success)
+
+			checkChain(t, 0, dsk, chain[:blocks], from)
+			dsk.Close()
+		}
+	}
+}
+
+// flipChecksum changes a byte in the checksum of the block with the
+// specified number.
+func flipChecksum(dbFile *os.File, dsk *Disk, number uint64) error {
+	e, _ := dsk.index.lookup(number)
+
+	checksum := make([]byte, 1)
+	if _, err := dbFile.ReadAt(checksum, e.Offset+4); err != nil {
+		return err
+	}
+	checksum[0] ^= 0xff
+
+	// The database file is opened to append, so the byte is written using
+	// a separate handle.
+	file, err := os.OpenFile(dbFile.Name(), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteAt(checksum, e.Offset+4)
+	return err
+}
@@ -0,0 +1,52 @@
+package disk
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// encodeBlock marshals the block using the specified encoding.
+func encodeBlock(blockFS storage.BlockFS, encoding string) ([]byte, error) {
+	switch encoding {
+	case storage.EncodingJSON:
+		return json.Marshal(blockFS)
+	case storage.EncodingRLP:
+		return rlp.EncodeToBytes(blockFS)
+	}
+
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// decodeBlock unmarshals a block written with either encoding. A JSON block
+// always starts with an opening brace, while an RLP block starts with the
+// header of a list which is never below 0xc0.
+func decodeBlock(data []byte) (storage.BlockFS, error) {
+	var blockFS storage.BlockFS
+
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &blockFS); err != nil {
+			return storage.BlockFS{}, err
+		}
+		return blockFS, nil
+	}
+
+	if err := rlp.DecodeBytes(data, &blockFS); err != nil {
+		return storage.BlockFS{}, err
+	}
+
+	return blockFS, nil
+}
+
+// changed reports if the block no longer matches its hash. The hash only
+// covers the header, so the transactions are checked against the root of the
+// transactions held in the header.
+func changed(blockFS storage.BlockFS) bool {
+	if blockFS.Block.Hash() != blockFS.Hash {
+		return true
+	}
+
+	return storage.TransRoot(blockFS.Block.Transactions) != blockFS.Block.Header.TransRoot
+}
@@ -0,0 +1,302 @@
+package disk
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// entry represents the information kept in the index for a single block.
+type entry struct {
+	Number   uint64            `json:"number"`
+	Offset   int64             `json:"offset"`   // Where the block starts in the database file.
+	Size     int64             `json:"size"`     // Length of the block without the record header.
+	Hash     string            `json:"hash"`     // Hash of the block.
+	Trans    []string          `json:"trans"`    // Signatures of the transactions in the block.
+	Accounts []storage.Account `json:"accounts"` // Accounts sending or receiving in the block.
+}
+
+// newEntry constructs the index entry for a block written at the specified
+// offset in the database file.
+func newEntry(blockFS storage.BlockFS, offset int64, size int64) entry {
+	e := entry{
+		Number: blockFS.Block.Header.Number,
+		Offset: offset,
+		Size:   size,
+		Hash:   blockFS.Hash,
+		Trans:  make([]string, len(blockFS.Block.Transactions)),
+	}
+
+	accounts := make(map[storage.Account]bool)
+	for i, tx := range blockFS.Block.Transactions {
+		e.Trans[i] = tx.SignatureString()
+
+		if from, err := tx.FromAccount(); err == nil {
+			accounts[from] = true
+		}
+		accounts[tx.To] = true
+	}
+
+	for account := range accounts {
+		e.Accounts = append(e.Accounts, account)
+	}
+	sort.Slice(e.Accounts, func(i, j int) bool { return e.Accounts[i] < e.Accounts[j] })
+
+	return e
+}
+
+// location represents where a transaction is found in the chain.
+type location struct {
+	number uint64
+	index  int
+}
+
+// index maintains lookups from block number to file offset, block hash to
+// number, transaction signature to block and account to blocks. The entries
+// are kept in a file next to the database so they don't need to be rebuilt
+// every time the node starts.
+type index struct {
+	path     string
+	file     *os.File
+	entries  []entry
+	hashes   map[string]uint64
+	trans    map[string]location
+	accounts map[storage.Account][]uint64
+}
+
+// openIndex loads the index for the database file. The index is rebuilt
+// from the database file if it's missing or doesn't match the database.
+func openIndex(path string, dbFile *os.File) (*index, error) {
+	idx := index{
+		path: path,
+	}
+
+	if err := idx.load(); err != nil || !idx.matches(dbFile) {
+		if err := idx.rebuild(dbFile); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	idx.file = file
+
+	return &idx, nil
+}
+
+// committed returns the offset in the database file where the last block
+// recorded in the index file ends. When the index file is missing or out of
+// order, 0 is returned.
+func committed(path string) int64 {
+	idx := index{
+		path: path,
+	}
+
+	if err := idx.load(); err != nil {
+		return 0
+	}
+
+	for i, e := range idx.entries {
+		if e.Number != uint64(i+1) {
+			return 0
+		}
+	}
+
+	return idx.next()
+}
+
+// close releases the index file.
+func (idx *index) close() error {
+	return idx.file.Close()
+}
+
+// lookup returns the entry for the block with the specified number.
+func (idx *index) lookup(number uint64) (entry, bool) {
+	if number == 0 || number > uint64(len(idx.entries)) {
+		return entry{}, false
+	}
+
+	e := idx.entries[number-1]
+	return e, e.Number == number
+}
+
+// next returns the offset in the database file for the next block.
+func (idx *index) next() int64 {
+	if len(idx.entries) == 0 {
+		return 0
+	}
+
+	last := idx.entries[len(idx.entries)-1]
+	return last.Offset + recordHeaderSize + last.Size
+}
+
+// add appends the entry to the index file and the lookups. If the write
+// fails, whatever part of the entry made it to the file is removed.
+func (idx *index) add(e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	info, err := idx.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := idx.file.Write(append(data, '\n')); err != nil {
+		idx.file.Truncate(info.Size())
+		return err
+	}
+
+	idx.insert(e)
+
+	return nil
+}
+
+// truncate removes the entries for every block after the specified number
+// and rewrites the index file.
+func (idx *index) truncate(number uint64) error {
+	if number >= uint64(len(idx.entries)) {
+		return nil
+	}
+
+	entries := idx.entries[:number]
+	if err := idx.write(entries); err != nil {
+		return err
+	}
+
+	idx.reset()
+	for _, e := range entries {
+		idx.insert(e)
+	}
+
+	file, err := os.OpenFile(idx.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	idx.file.Close()
+	idx.file = file
+
+	return nil
+}
+
+// =============================================================================
+
+// load reads the entries from the index file.
+func (idx *index) load() error {
+	idx.reset()
+
+	file, err := os.Open(idx.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var e entry
+		if err := decoder.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		idx.insert(e)
+	}
+}
+
+// matches checks the entries are in order and end where the database
+// file ends.
+func (idx *index) matches(dbFile *os.File) bool {
+	for i, e := range idx.entries {
+		if e.Number != uint64(i+1) {
+			return false
+		}
+	}
+
+	info, err := dbFile.Stat()
+	if err != nil {
+		return false
+	}
+
+	return idx.next() == info.Size()
+}
+
+// rebuild reads every block in the database file to recreate the entries
+// and writes them to the index file.
+func (idx *index) rebuild(dbFile *os.File) error {
+	idx.reset()
+
+	records, err := newRecordReader(dbFile)
+	if err != nil {
+		return err
+	}
+
+	for {
+		blockFS, offset, size, err := records.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		idx.insert(newEntry(blockFS, offset, size))
+	}
+
+	return idx.write(idx.entries)
+}
+
+// write replaces the index file with one holding the specified entries.
+func (idx *index) write(entries []entry) error {
+	tmpPath := idx.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		if _, err := tmpFile.Write(append(data, '\n')); err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, idx.path)
+}
+
+// reset clears the entries and lookups.
+func (idx *index) reset() {
+	idx.entries = nil
+	idx.hashes = make(map[string]uint64)
+	idx.trans = make(map[string]location)
+	idx.accounts = make(map[storage.Account][]uint64)
+}
+
+// insert adds the entry to the lookups.
+func (idx *index) insert(e entry) {
+	idx.entries = append(idx.entries, e)
+	idx.hashes[e.Hash] = e.Number
+
+	for i, sig := range e.Trans {
+		idx.trans[sig] = location{number: e.Number, index: i}
+	}
+
+	for _, account := range e.Accounts {
+		idx.accounts[account] = append(idx.accounts[account], e.Number)
+	}
+}
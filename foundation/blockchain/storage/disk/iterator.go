@@ -0,0 +1,95 @@
+package disk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// recordReader reads the blocks from the database file one at a time,
+// verifying the checksum and hash of each block.
+type recordReader struct {
+	reader *bufio.Reader
+	offset int64
+	total  int64
+}
+
+// newRecordReader constructs a reader for the blocks written to the
+// database file so far.
+func newRecordReader(dbFile *os.File) (*recordReader, error) {
+	info, err := dbFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	rr := recordReader{
+		reader: bufio.NewReader(io.NewSectionReader(dbFile, 0, info.Size())),
+		total:  info.Size(),
+	}
+
+	return &rr, nil
+}
+
+// next returns the next block along with its offset and size in the
+// database file. When there are no more blocks, io.EOF is returned.
+func (rr *recordReader) next() (storage.BlockFS, int64, int64, error) {
+	data, err := readRecord(rr.reader, rr.offset, rr.total)
+	if err != nil {
+		return storage.BlockFS{}, 0, 0, err
+	}
+
+	blockFS, err := decodeBlock(data)
+	if err != nil {
+		return storage.BlockFS{}, 0, 0, fmt.Errorf("block at offset %d: %w", rr.offset, err)
+	}
+
+	if changed(blockFS) {
+		return storage.BlockFS{}, 0, 0, fmt.Errorf("block %d has been changed", blockFS.Block.Header.Number)
+	}
+
+	offset := rr.offset
+	size := int64(len(data))
+	rr.offset += recordHeaderSize + size
+
+	return blockFS, offset, size, nil
+}
+
+// =============================================================================
+
+// iterator streams the blocks from the database file.
+type iterator struct {
+	records *recordReader
+	err     error
+	done    bool
+}
+
+// Next returns the next block in the chain. Once an error is returned, the
+// following call ends the iteration.
+func (it *iterator) Next() (storage.Block, error) {
+	if it.err == nil {
+		var blockFS storage.BlockFS
+		blockFS, _, _, it.err = it.records.next()
+		if it.err == nil {
+			return blockFS.Block, nil
+		}
+	}
+
+	err := it.err
+	it.err = io.EOF
+
+	if errors.Is(err, io.EOF) {
+		it.done = true
+		return storage.Block{}, nil
+	}
+
+	return storage.Block{}, err
+}
+
+// Done reports if the end of the chain has been reached.
+func (it *iterator) Done() bool {
+	return it.done
+}
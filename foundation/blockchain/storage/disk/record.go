@@ -0,0 +1,85 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// recordHeaderSize is the number of bytes in front of each block in the
+// database file. The header holds the length of the block followed by the
+// CRC32 checksum of the block, both as big endian uint32 values.
+const recordHeaderSize = 8
+
+// errTornRecord is returned when a record runs past the end of the database
+// file, or the last record fails its checksum. This happens when the node
+// stops in the middle of writing a block, but only to the last block written.
+var errTornRecord = errors.New("torn record")
+
+// encodeRecord frames the data with its length and checksum.
+func encodeRecord(data []byte) []byte {
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	return record
+}
+
+// decodeRecord checks the length and checksum of the record and returns the
+// data it holds.
+func decodeRecord(record []byte) ([]byte, error) {
+	if len(record) < recordHeaderSize {
+		return nil, errors.New("record is too short")
+	}
+
+	data := record[recordHeaderSize:]
+	if int(binary.BigEndian.Uint32(record[0:4])) != len(data) {
+		return nil, errors.New("record length mismatch")
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(record[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+
+	return data, nil
+}
+
+// readRecord reads the next record starting at the specified offset and
+// returns the data it holds. The total size of the file is used to tell a
+// record torn by a crash from a corrupted record in the middle of the file.
+// When there are no more records, io.EOF is returned.
+func readRecord(reader *bufio.Reader, offset int64, total int64) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: offset %d: incomplete header", errTornRecord, offset)
+		}
+		return nil, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[0:4]))
+	if offset+recordHeaderSize+size > total {
+		return nil, fmt.Errorf("%w: offset %d: incomplete data", errTornRecord, offset)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		if offset+recordHeaderSize+size == total {
+			return nil, fmt.Errorf("%w: offset %d: checksum mismatch", errTornRecord, offset)
+		}
+		return nil, fmt.Errorf("record at offset %d: checksum mismatch", offset)
+	}
+
+	return data, nil
+}
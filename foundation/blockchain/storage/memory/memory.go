@@ -0,0 +1,169 @@
+// Package memory implements the storage interface in memory. Nothing is
+// persisted, which makes it useful for tests and ephemeral nodes.
+package memory
+
+import (
+	"sync"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// Memory manages reading and writing of blocks held in memory. The chain
+// starts at block 1 and has no gaps, so a block is held at the position
+// before its number.
+type Memory struct {
+	blocks []storage.BlockFS
+	hashes map[string]int
+	mu     sync.RWMutex
+}
+
+// New constructs an empty blockchain storage in memory.
+func New() *Memory {
+	return &Memory{
+		hashes: make(map[string]int),
+	}
+}
+
+// Close releases the blocks held in memory.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks = nil
+	m.hashes = make(map[string]int)
+
+	return nil
+}
+
+// Write adds a new block to the chain.
+func (m *Memory) Write(blockFS storage.BlockFS) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hashes[blockFS.Hash] = len(m.blocks)
+	m.blocks = append(m.blocks, blockFS)
+
+	return nil
+}
+
+// GetBlock returns the block with the specified number.
+func (m *Memory) GetBlock(number uint64) (storage.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if number == 0 || number > uint64(len(m.blocks)) {
+		return storage.Block{}, storage.ErrNotFound
+	}
+
+	return m.blocks[number-1].Block, nil
+}
+
+// GetBlockByHash returns the block with the specified hash.
+func (m *Memory) GetBlockByHash(hash string) (storage.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	idx, exists := m.hashes[hash]
+	if !exists {
+		return storage.Block{}, storage.ErrNotFound
+	}
+
+	return m.blocks[idx].Block, nil
+}
+
+// GetBlockByTransaction returns the block that includes the transaction with
+// the specified signature, along with the index of the transaction in the
+// block.
+func (m *Memory) GetBlockByTransaction(sig string) (storage.Block, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, blockFS := range m.blocks {
+		for i, tx := range blockFS.Block.Transactions {
+			if tx.SignatureString() == sig {
+				return blockFS.Block, i, nil
+			}
+		}
+	}
+
+	return storage.Block{}, 0, storage.ErrNotFound
+}
+
+// GetBlocksByAccount returns the blocks with transactions sent or received
+// by the specified account.
+func (m *Memory) GetBlocksByAccount(account storage.Account) ([]storage.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var blocks []storage.Block
+	for _, blockFS := range m.blocks {
+		for _, tx := range blockFS.Block.Transactions {
+			from, err := tx.FromAccount()
+			if err != nil {
+				continue
+			}
+			if from == account || tx.To == account {
+				blocks = append(blocks, blockFS.Block)
+				break
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+// ForEach returns an iterator over the blocks in the chain in order. The
+// iterator works from the blocks held when it was created.
+func (m *Memory) ForEach() storage.Iterator {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	blocks := make([]storage.BlockFS, len(m.blocks))
+	copy(blocks, m.blocks)
+
+	return &iterator{blocks: blocks}
+}
+
+// Reset rolls the chain back so the block with the specified number becomes
+// the latest block. Passing 0 removes every block from storage.
+func (m *Memory) Reset(number uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if number >= uint64(len(m.blocks)) {
+		return nil
+	}
+
+	for _, removed := range m.blocks[number:] {
+		delete(m.hashes, removed.Hash)
+	}
+	m.blocks = m.blocks[:number]
+
+	return nil
+}
+
+// =============================================================================
+
+// iterator walks over a copy of the blocks held in memory.
+type iterator struct {
+	blocks []storage.BlockFS
+	done   bool
+}
+
+// Next returns the next block in the chain.
+func (it *iterator) Next() (storage.Block, error) {
+	if len(it.blocks) == 0 {
+		it.done = true
+		return storage.Block{}, nil
+	}
+
+	block := it.blocks[0].Block
+	it.blocks = it.blocks[1:]
+
+	return block, nil
+}
+
+// Done reports if the end of the chain has been reached.
+func (it *iterator) Done() bool {
+	return it.done
+}
@@ -0,0 +1,122 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage/memory"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// miner mines every block in the chain.
+const miner = storage.Account("0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9")
+
+// newChain constructs a chain with the specified number of empty blocks.
+func newChain(count int) []storage.BlockFS {
+	var chain []storage.BlockFS
+	var parent storage.Block
+	for i := 0; i < count; i++ {
+		block := storage.NewBlock(miner, 1, 2, parent, nil, "")
+		chain = append(chain, storage.BlockFS{Hash: block.Hash(), Block: block})
+		parent = block
+	}
+
+	return chain
+}
+
+func TestMemory(t *testing.T) {
+	const blocks = 5
+	chain := newChain(blocks)
+
+	t.Log("Given the need to keep the chain in memory.")
+	{
+		t.Logf("\tTest 0:\tWhen writing %d blocks.", blocks)
+		{
+			mem := memory.New()
+			for _, blockFS := range chain {
+				if err := mem.Write(blockFS); err != nil {
+					t.Fatalf("\t%s\tTest 0:\tShould be able to write the blocks: %s", failed, err)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to write the blocks.", success)
+
+			for _, blockFS := range chain {
+				number := blockFS.Block.Header.Number
+
+				block, err := mem.GetBlock(number)
+				if err != nil || block.Hash() != blockFS.Hash {
+					t.Fatalf("\t%s\tTest 0:\tShould get block %d by number: %v", failed, number, err)
+				}
+
+				block, err = mem.GetBlockByHash(blockFS.Hash)
+				if err != nil || block.Header.Number != number {
+					t.Fatalf("\t%s\tTest 0:\tShould get block %d by hash: %v", failed, number, err)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould get every block by number and hash.", success)
+
+			for _, number := range []uint64{0, blocks + 1} {
+				if _, err := mem.GetBlock(number); !errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("\t%s\tTest 0:\tShould not find block %d: %v", failed, number, err)
+				}
+			}
+			t.Logf("\t%s\tTest 0:\tShould not find blocks outside the chain.", success)
+
+			var number uint64
+			iter := mem.ForEach()
+			for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+				if err != nil {
+					t.Fatalf("\t%s\tTest 0:\tShould be able to iterate over the blocks: %s", failed, err)
+				}
+				number++
+				if block.Header.Number != number {
+					t.Fatalf("\t%s\tTest 0:\tShould iterate over the blocks in order: got %d, exp %d", failed, block.Header.Number, number)
+				}
+			}
+			if number != blocks {
+				t.Fatalf("\t%s\tTest 0:\tShould iterate over every block: got %d, exp %d", failed, number, blocks)
+			}
+			t.Logf("\t%s\tTest 0:\tShould iterate over every block in order.", success)
+		}
+
+		t.Logf("\tTest 1:\tWhen resetting the chain to block 2.")
+		{
+			mem := memory.New()
+			for _, blockFS := range chain {
+				if err := mem.Write(blockFS); err != nil {
+					t.Fatalf("\t%s\tTest 1:\tShould be able to write the blocks: %s", failed, err)
+				}
+			}
+
+			if err := mem.Reset(2); err != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould be able to reset the chain: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 1:\tShould be able to reset the chain.", success)
+
+			if _, err := mem.GetBlock(2); err != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould keep block 2: %s", failed, err)
+			}
+			if _, err := mem.GetBlock(3); !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("\t%s\tTest 1:\tShould remove block 3: %v", failed, err)
+			}
+			if _, err := mem.GetBlockByHash(chain[2].Hash); !errors.Is(err, storage.ErrNotFound) {
+				t.Fatalf("\t%s\tTest 1:\tShould remove the hash of block 3: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 1:\tShould remove the blocks after block 2.", success)
+
+			if err := mem.Write(chain[2]); err != nil {
+				t.Fatalf("\t%s\tTest 1:\tShould be able to write block 3 again: %s", failed, err)
+			}
+			if block, err := mem.GetBlock(3); err != nil || block.Hash() != chain[2].Hash {
+				t.Fatalf("\t%s\tTest 1:\tShould get block 3 again: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 1:\tShould be able to write block 3 again.", success)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Set of encodings supported for blocks and transactions.
+const (
+	EncodingJSON = "json"
+	EncodingRLP  = "rlp"
+)
+
+// Set of content types used to exchange blocks and transactions over HTTP.
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeRLP  = "application/x-rlp"
+)
+
+// MaxBlockBytes is the size of the largest encoded block or transaction
+// accepted from a peer.
+const MaxBlockBytes = 1 << 20
+
+// Canonical serialization
+//
+// Transactions are signed and blocks are hashed over the RLP encoding of the
+// values, as defined by the Ethereum yellow paper. Integers are big endian
+// with no leading zeros, so zero is the empty string (0x80). Accounts and
+// hashes are the bytes of their hex strings exactly as written, including
+// the 0x prefix and the case of the letters. A nil and an empty byte slice
+// encode the same.
+//
+//	UserTx:      [chain_id, nonce, to, value, tip, data]
+//	BlockTx:     [chain_id, nonce, to, value, tip, data, v, r, s, timestamp, gas]
+//	BlockHeader: [parent_hash, miner_account, difficulty, number, total_tip,
+//	              total_gas, timestamp, trans_root, state_root, nonce]
+//
+// A transaction is signed over keccak256("\x19Ardan Signed Message:\n32" ||
+// keccak256(rlp(UserTx))). A transaction hash is sha256(rlp(BlockTx)) and a
+// block hash is sha256(rlp(BlockHeader)). Since the chain id is part of
+// what gets signed, a transaction can't be replayed on a chain with a
+// different id.
+//
+// Test vectors
+//
+// The transaction below is signed with the private key
+// 4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318 which
+// belongs to the account 0x2c7536E3605D9C16a7a3D7b1898e529396a65c23.
+//
+//	UserTx{ChainID: 1, Nonce: 1, To: "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", Value: 1000, Tip: 10}
+//
+//	rlp:        f20101aa30786245453641434538323665433344453142363334393838
+//	            38423931353142393235323246374637368203e80a80
+//	keccak256:  5264defe3edcea3aa276e4ea2e7abcddbb9c205c7db9b0779ec3d5e9c2db2380
+//	stamped:    ccccfc221979a4342596181d1b1ecb290d1acec799755baffe936b487430b53d
+//	signature:  0x283f8b2f0f0c15a2701c04dd1b059a6f6af3a4c6480af9acabaa8df847049a59
+//	            095366699f60bcc455da5d5ad64b4e7b3e48006a3cbd3505a240c9cadbfe98091e
+//
+// The header below has the hash shown.
+//
+//	BlockHeader{
+//		ParentHash:   "0002324f43d07e7a26e28b2e6fc60e2b6a7cb190b7aee34d8a560e1f685822c6",
+//		MinerAccount: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+//		Difficulty:   12,
+//		Number:       3,
+//		TotalTip:     10,
+//		TotalGas:     15,
+//		TimeStamp:    1792173864,
+//		TransRoot:    "aa",
+//		StateRoot:    "bb",
+//		Nonce:        7,
+//	}
+//
+//	rlp:     f87db84030303032333234663433643037653761323665323862326536666336
+//	         3065326236613763623139306237616565333464386135363065316636383538
+//	         32326336aa307846656633313134383343633034306531413839666239626234
+//	         3639656542384137303933354546380c030a0f846ad2672882616182626207
+//	sha256:  192e620e49e77626a59ee8baf3b26ec9c6f0693a0707baa066317bd929c195d6
+
+// rlpHeader is the binary encoding of a BlockHeader.
+type rlpHeader struct {
+	ParentHash   string
+	MinerAccount Account
+	Difficulty   uint64
+	Number       uint64
+	TotalTip     uint64
+	TotalGas     uint64
+	TimeStamp    uint64
+	TransRoot    string
+	StateRoot    string
+	Nonce        uint64
+}
+
+// rlpTx is the binary encoding of a BlockTx.
+type rlpTx struct {
+	ChainID   uint16
+	Nonce     uint64
+	To        Account
+	Value     uint64
+	Tip       uint64
+	Data      []byte
+	V         *big.Int
+	R         *big.Int
+	S         *big.Int
+	TimeStamp uint64
+	Gas       uint64
+}
+
+// EncodeRLP implements the rlp.Encoder interface.
+func (bh BlockHeader) EncodeRLP(w io.Writer) error {
+	if bh.Difficulty < 0 {
+		return fmt.Errorf("invalid difficulty %d", bh.Difficulty)
+	}
+
+	rh := rlpHeader{
+		ParentHash:   bh.ParentHash,
+		MinerAccount: bh.MinerAccount,
+		Difficulty:   uint64(bh.Difficulty),
+		Number:       bh.Number,
+		TotalTip:     uint64(bh.TotalTip),
+		TotalGas:     uint64(bh.TotalGas),
+		TimeStamp:    bh.TimeStamp,
+		TransRoot:    bh.TransRoot,
+		StateRoot:    bh.StateRoot,
+		Nonce:        bh.Nonce,
+	}
+
+	return rlp.Encode(w, rh)
+}
+
+// DecodeRLP implements the rlp.Decoder interface.
+func (bh *BlockHeader) DecodeRLP(s *rlp.Stream) error {
+	var rh rlpHeader
+	if err := s.Decode(&rh); err != nil {
+		return err
+	}
+
+	*bh = BlockHeader{
+		ParentHash:   rh.ParentHash,
+		MinerAccount: rh.MinerAccount,
+		Difficulty:   int(rh.Difficulty),
+		Number:       rh.Number,
+		TotalTip:     uint(rh.TotalTip),
+		TotalGas:     uint(rh.TotalGas),
+		TimeStamp:    rh.TimeStamp,
+		TransRoot:    rh.TransRoot,
+		StateRoot:    rh.StateRoot,
+		Nonce:        rh.Nonce,
+	}
+
+	return nil
+}
+
+// EncodeRLP implements the rlp.Encoder interface.
+func (tx BlockTx) EncodeRLP(w io.Writer) error {
+	rt := rlpTx{
+		ChainID:   tx.ChainID,
+		Nonce:     uint64(tx.Nonce),
+		To:        tx.To,
+		Value:     uint64(tx.Value),
+		Tip:       uint64(tx.Tip),
+		Data:      tx.Data,
+		V:         tx.V,
+		R:         tx.R,
+		S:         tx.S,
+		TimeStamp: tx.TimeStamp,
+		Gas:       uint64(tx.Gas),
+	}
+
+	return rlp.Encode(w, rt)
+}
+
+// DecodeRLP implements the rlp.Decoder interface.
+func (tx *BlockTx) DecodeRLP(s *rlp.Stream) error {
+	var rt rlpTx
+	if err := s.Decode(&rt); err != nil {
+		return err
+	}
+
+	*tx = BlockTx{
+		SignedTx: SignedTx{
+			UserTx: UserTx{
+				ChainID: rt.ChainID,
+				Nonce:   uint(rt.Nonce),
+				To:      rt.To,
+				Value:   uint(rt.Value),
+				Tip:     uint(rt.Tip),
+				Data:    rt.Data,
+			},
+			V: rt.V,
+			R: rt.R,
+			S: rt.S,
+		},
+		TimeStamp: rt.TimeStamp,
+		Gas:       uint(rt.Gas),
+	}
+
+	return nil
+}
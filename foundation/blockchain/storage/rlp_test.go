@@ -0,0 +1,140 @@
+package storage_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// The vectors are documented in rlp.go.
+
+func TestUserTxVector(t *testing.T) {
+	const (
+		pk        = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+		from      = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+		expRLP    = "f20101aa3078624545364143453832366543334445314236333439383838423931353142393235323246374637368203e80a80"
+		expKeccak = "5264defe3edcea3aa276e4ea2e7abcddbb9c205c7db9b0779ec3d5e9c2db2380"
+		expStamp  = "ccccfc221979a4342596181d1b1ecb290d1acec799755baffe936b487430b53d"
+		expSig    = "0x283f8b2f0f0c15a2701c04dd1b059a6f6af3a4c6480af9acabaa8df847049a59095366699f60bcc455da5d5ad64b4e7b3e48006a3cbd3505a240c9cadbfe98091e"
+	)
+
+	userTx := storage.UserTx{
+		ChainID: 1,
+		Nonce:   1,
+		To:      "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76",
+		Value:   1000,
+		Tip:     10,
+	}
+
+	t.Log("Given the need to sign a user transaction the same way as other wallets.")
+	{
+		t.Logf("\tTest 0:\tWhen handling the documented transaction.")
+		{
+			data, err := rlp.EncodeToBytes(userTx)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to encode the transaction: %s", failed, err)
+			}
+			if got := hex.EncodeToString(data); got != expRLP {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected RLP bytes: got %s, exp %s", failed, got, expRLP)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected RLP bytes.", success)
+
+			txHash := crypto.Keccak256(data)
+			if got := hex.EncodeToString(txHash); got != expKeccak {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected keccak256: got %s, exp %s", failed, got, expKeccak)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected keccak256.", success)
+
+			stamped := crypto.Keccak256([]byte("\x19Ardan Signed Message:\n32"), txHash)
+			if got := hex.EncodeToString(stamped); got != expStamp {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected stamped hash: got %s, exp %s", failed, got, expStamp)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected stamped hash.", success)
+
+			privateKey, err := crypto.HexToECDSA(pk)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to load the private key: %s", failed, err)
+			}
+
+			signedTx, err := userTx.Sign(privateKey)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to sign the transaction: %s", failed, err)
+			}
+			if got := signedTx.SignatureString(); got != expSig {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected signature: got %s, exp %s", failed, got, expSig)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected signature.", success)
+
+			// Recover the sender from a copy that only has the signature
+			// values, so nothing is left over from signing.
+			received := storage.SignedTx{UserTx: userTx, V: signedTx.V, R: signedTx.R, S: signedTx.S}
+			account, err := received.FromAccount()
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to recover the sender: %s", failed, err)
+			}
+			if account != from {
+				t.Fatalf("\t%s\tTest 0:\tShould recover the expected sender: got %s, exp %s", failed, account, from)
+			}
+			t.Logf("\t%s\tTest 0:\tShould recover the expected sender.", success)
+		}
+	}
+}
+
+func TestBlockHeaderVector(t *testing.T) {
+	const (
+		expRLP  = "f87db84030303032333234663433643037653761323665323862326536666336306532623661376362313930623761656533346438613536306531663638353832326336aa3078466566333131343833436330343065314138396662396262343639656542384137303933354546380c030a0f846ad2672882616182626207"
+		expHash = "192e620e49e77626a59ee8baf3b26ec9c6f0693a0707baa066317bd929c195d6"
+	)
+
+	header := storage.BlockHeader{
+		ParentHash:   "0002324f43d07e7a26e28b2e6fc60e2b6a7cb190b7aee34d8a560e1f685822c6",
+		MinerAccount: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		Difficulty:   12,
+		Number:       3,
+		TotalTip:     10,
+		TotalGas:     15,
+		TimeStamp:    1792173864,
+		TransRoot:    "aa",
+		StateRoot:    "bb",
+		Nonce:        7,
+	}
+
+	t.Log("Given the need to hash a block header the same way on every node.")
+	{
+		t.Logf("\tTest 0:\tWhen handling the documented header.")
+		{
+			data, err := rlp.EncodeToBytes(header)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to encode the header: %s", failed, err)
+			}
+			if got := hex.EncodeToString(data); got != expRLP {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected RLP bytes: got %s, exp %s", failed, got, expRLP)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected RLP bytes.", success)
+
+			block := storage.Block{Header: header}
+			if got := block.Hash(); got != expHash {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected hash: got %s, exp %s", failed, got, expHash)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected hash.", success)
+
+			var decoded storage.BlockHeader
+			if err := rlp.DecodeBytes(data, &decoded); err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to decode the header: %s", failed, err)
+			}
+			if decoded != header {
+				t.Fatalf("\t%s\tTest 0:\tShould get the same header back: got %+v, exp %+v", failed, decoded, header)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the same header back.", success)
+		}
+	}
+}
@@ -1,95 +1,35 @@
 package storage
 
-import (
-	"bufio"
-	"encoding/json"
-	"fmt"
-	"os"
-	"sync"
-)
-
-// Storage manages reading and writing of blocks to storage.
-type Storage struct {
-	dbPath string
-	dbFile *os.File
-	mu     sync.Mutex
-}
-
-// New provides access to blockchain storage.
-func New(dbPath string) (*Storage, error) {
-
-	// Open the blockchain database file with append.
-	dbFile, err := os.OpenFile(dbPath, os.O_APPEND|os.O_RDWR, 0600)
-	if err != nil {
-		return nil, err
-	}
-
-	strg := Storage{
-		dbPath: dbPath,
-		dbFile: dbFile,
-	}
-
-	return &strg, nil
-}
-
-// Close cleanly releases the storage area.
-func (str *Storage) Close() {
-	str.mu.Lock()
-	defer str.mu.Unlock()
-
-	str.dbFile.Close()
-}
-
-// Write adds a new block to the chain.
-func (str *Storage) Write(block BlockFS) error {
-	str.mu.Lock()
-	defer str.mu.Unlock()
-
-	// Marshal the block for writing to disk.
-	blockFSJson, err := json.Marshal(block)
-	if err != nil {
-		return err
-	}
-
-	// Write the new block to the chain on disk.
-	if _, err := str.dbFile.Write(append(blockFSJson, '\n')); err != nil {
-		return err
-	}
-
-	return nil
+import "errors"
+
+// ErrNotFound is returned when a block is not found in storage.
+var ErrNotFound = errors.New("block not found")
+
+// Storage represents the behavior required to be implemented by any package
+// providing support for reading and writing the blockchain.
+type Storage interface {
+	Write(blockFS BlockFS) error
+	GetBlock(number uint64) (Block, error)
+	GetBlockByHash(hash string) (Block, error)
+	GetBlockByTransaction(sig string) (Block, int, error)
+	GetBlocksByAccount(account Account) ([]Block, error)
+	ForEach() Iterator
+	Reset(number uint64) error
+	Close() error
 }
 
-// =============================================================================
-
-// ReadAllBlocks loads all existing blocks from storage into memory. In a real
-// world situation this would require a lot of memory.
-func (str *Storage) ReadAllBlocks() ([]Block, error) {
-	dbFile, err := os.Open(str.dbPath)
-	if err != nil {
-		return nil, err
-	}
-	defer dbFile.Close()
-
-	var blockNum int
-	var blocks []Block
-	scanner := bufio.NewScanner(dbFile)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
-
-		var blockFS BlockFS
-		if err := json.Unmarshal(scanner.Bytes(), &blockFS); err != nil {
-			return nil, err
-		}
-
-		if blockFS.Block.Hash() != blockFS.Hash {
-			return nil, fmt.Errorf("block %d has been changed", blockNum)
-		}
-
-		blocks = append(blocks, blockFS.Block)
-		blockNum++
-	}
-
-	return blocks, nil
+// Iterator represents the behavior required to be implemented by any package
+// providing support to iterate over the blocks in order. Next returns the
+// next block and Done reports the end of the chain was reached, in which case
+// the block returned by Next is empty and should be ignored.
+//
+//	iter := strg.ForEach()
+//	for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+//	    if err != nil {
+//	        return err
+//	    }
+//	}
+type Iterator interface {
+	Next() (Block, error)
+	Done() bool
 }
@@ -10,23 +10,31 @@ import (
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 )
 
+// ErrInvalidChainID is returned when a transaction was signed for a
+// different chain.
+var ErrInvalidChainID = errors.New("transaction signed for a different chain")
+
+// =============================================================================
+
 // UserTx is the transactional data submitted by a user.
 type UserTx struct {
-	Nonce uint    `json:"nonce"` // Unique id for the transaction supplied by the user.
-	To    Account `json:"to"`    // Account receiving the benefit of the transaction.
-	Value uint    `json:"value"` // Monetary value received from this transaction.
-	Tip   uint    `json:"tip"`   // Tip offered by the sender as an incentive to mine this transaction.
-	Data  []byte  `json:"data"`  // Extra data related to the transaction.
+	ChainID uint16  `json:"chain_id"` // Chain the transaction is signed for, from the genesis file.
+	Nonce   uint    `json:"nonce"`    // Unique id for the transaction supplied by the user.
+	To      Account `json:"to"`       // Account receiving the benefit of the transaction.
+	Value   uint    `json:"value"`    // Monetary value received from this transaction.
+	Tip     uint    `json:"tip"`      // Tip offered by the sender as an incentive to mine this transaction.
+	Data    []byte  `json:"data"`     // Extra data related to the transaction.
 }
 
 // NewUserTx constructs a new user transaction.
-func NewUserTx(nonce uint, to Account, value uint, tip uint, data []byte) (UserTx, error) {
+func NewUserTx(chainID uint16, nonce uint, to Account, value uint, tip uint, data []byte) (UserTx, error) {
 	userTx := UserTx{
-		Nonce: nonce,
-		To:    to,
-		Value: value,
-		Tip:   tip,
-		Data:  data,
+		ChainID: chainID,
+		Nonce:   nonce,
+		To:      to,
+		Value:   value,
+		Tip:     tip,
+		Data:    data,
 	}
 
 	return userTx, nil
@@ -46,12 +54,14 @@ func (tx UserTx) Sign(privateKey *ecdsa.PrivateKey) (SignedTx, error) {
 		return SignedTx{}, err
 	}
 
-	// Construct the signed transaction.
+	// Construct the signed transaction. The signer is known so it doesn't
+	// need to be recovered from the signature.
 	signedTx := SignedTx{
 		UserTx: tx,
 		V:      v,
 		R:      r,
 		S:      s,
+		from:   PublicKeyToAccount(privateKey.PublicKey),
 	}
 
 	return signedTx, nil
@@ -66,13 +76,25 @@ type SignedTx struct {
 	V *big.Int `json:"v"` // Recovery identifier, either 29 or 30 with ardanID.
 	R *big.Int `json:"r"` // First coordinate of the ECDSA signature.
 	S *big.Int `json:"s"` // Second coordinate of the ECDSA signature.
+
+	// from is the account recovered from the signature when the transaction
+	// was validated. It's kept in memory only and never serialized, so it
+	// can't be supplied by whoever sent the transaction.
+	from Account
 }
 
-// Validate verifies the transaction has a proper signature that conforms to our
-// standards and is associated with the data claimed to be signed. It also
-// checks the format of the to account.
-func (tx SignedTx) Validate() error {
-	if err := signature.VerifySignature(tx.UserTx, tx.V, tx.R, tx.S); err != nil {
+// Validate verifies the transaction was signed for the specified chain and
+// has a proper signature that conforms to our standards and is associated
+// with the data claimed to be signed. It also checks the format of the to
+// account. The account that signed the transaction is recovered once here
+// and kept for FromAccount.
+func (tx *SignedTx) Validate(chainID uint16) error {
+	if tx.ChainID != chainID {
+		return fmt.Errorf("%w, got %d, exp %d", ErrInvalidChainID, tx.ChainID, chainID)
+	}
+
+	address, err := signature.FromAddress(tx.UserTx, tx.V, tx.R, tx.S)
+	if err != nil {
 		return err
 	}
 
@@ -80,11 +102,19 @@ func (tx SignedTx) Validate() error {
 		return errors.New("invalid account for to account")
 	}
 
+	tx.from = Account(address)
+
 	return nil
 }
 
-// FromAccount extracts the account that signed the transaction.
+// FromAccount extracts the account that signed the transaction. The account
+// recovered when the transaction was validated is used when there is one,
+// otherwise it's recovered from the signature.
 func (tx SignedTx) FromAccount() (Account, error) {
+	if tx.from != "" {
+		return tx.from, nil
+	}
+
 	address, err := signature.FromAddress(tx.UserTx, tx.V, tx.R, tx.S)
 	return Account(address), err
 }
@@ -121,3 +151,13 @@ func NewBlockTx(signedTx SignedTx, gas uint) BlockTx {
 		Gas:       gas,
 	}
 }
+
+// Hash returns the unique hash for the block transaction.
+func (tx BlockTx) Hash() string {
+	return signature.Hash(tx)
+}
+
+// HashBytes returns the unique hash for the block transaction as raw bytes.
+func (tx BlockTx) HashBytes() []byte {
+	return signature.HashBytes(tx)
+}
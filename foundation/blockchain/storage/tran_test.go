@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// signedTx returns a block transaction signed by a new private key, along
+// with the account of the key.
+func signedTx(tb testing.TB) (BlockTx, Account) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		tb.Fatalf("Should be able to generate a private key: %s", err)
+	}
+
+	userTx, err := NewUserTx(1, 1, "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", 1000, 10, nil)
+	if err != nil {
+		tb.Fatalf("Should be able to construct the transaction: %s", err)
+	}
+
+	tx, err := userTx.Sign(privateKey)
+	if err != nil {
+		tb.Fatalf("Should be able to sign the transaction: %s", err)
+	}
+
+	return NewBlockTx(tx, 15), PublicKeyToAccount(privateKey.PublicKey)
+}
+
+func TestFromNotSerialized(t *testing.T) {
+	tx, account := signedTx(t)
+
+	// Pretend the sender was recovered as some other account, which must
+	// never reach a peer or come back from one.
+	tx.from = "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8"
+
+	tt := []struct {
+		name      string
+		roundTrip func(tx BlockTx) (BlockTx, error)
+	}{
+		{"JSON", func(tx BlockTx) (BlockTx, error) {
+			data, err := json.Marshal(tx)
+			if err != nil {
+				return BlockTx{}, err
+			}
+			var got BlockTx
+			err = json.Unmarshal(data, &got)
+			return got, err
+		}},
+		{"RLP", func(tx BlockTx) (BlockTx, error) {
+			data, err := rlp.EncodeToBytes(tx)
+			if err != nil {
+				return BlockTx{}, err
+			}
+			var got BlockTx
+			err = rlp.DecodeBytes(data, &got)
+			return got, err
+		}},
+	}
+
+	t.Log("Given the need to only trust the sender recovered by this node.")
+	{
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen a transaction goes through a %s round trip.", testID, test.name)
+			{
+				got, err := test.roundTrip(tx)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to round trip the transaction: %s", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to round trip the transaction.", success, testID)
+
+				if got.from != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould not carry the sender: got %s", failed, testID, got.from)
+				}
+				t.Logf("\t%s\tTest %d:\tShould not carry the sender.", success, testID)
+
+				from, err := got.FromAccount()
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to recover the sender: %s", failed, testID, err)
+				}
+				if from != account {
+					t.Fatalf("\t%s\tTest %d:\tShould recover the signer: got %s, exp %s", failed, testID, from, account)
+				}
+				t.Logf("\t%s\tTest %d:\tShould recover the signer.", success, testID)
+			}
+		}
+	}
+}
+
+func BenchmarkFromAccount(b *testing.B) {
+	tx, _ := signedTx(b)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := tx.FromAccount(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		uncached := tx
+		uncached.from = ""
+
+		for i := 0; i < b.N; i++ {
+			if _, err := uncached.FromAccount(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,319 @@
+// Package keystore stores private keys encrypted with a passphrase. Files
+// use the Web3 Secret Storage format (version 3) so they can be exchanged
+// with Ethereum wallets, and are encrypted and decrypted by the go-ethereum
+// keystore package. Other secrets, like a mnemonic phrase, are stored the
+// same way without an account.
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+)
+
+// ErrDecrypt is returned when a key can't be decrypted with the passphrase.
+var ErrDecrypt = ethkeystore.ErrDecrypt
+
+// Set of limits for the key derivation parameters read from a keystore file.
+// The parameters control how much memory and time it takes to derive a key,
+// so a file can't be allowed to ask for an unbounded amount. The limits are
+// well above the values used by Ethereum wallets.
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 16
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+	maxPBKDF2C      = 1 << 24
+	maxDKLen        = 64
+)
+
+// version is the version of the Web3 Secret Storage format.
+const version = 3
+
+// =============================================================================
+
+// keyFile represents the contents of a keystore file. A file holding a
+// secret other than a private key has no address.
+type keyFile struct {
+	Address string                 `json:"address,omitempty"`
+	Crypto  ethkeystore.CryptoJSON `json:"crypto"`
+	ID      string                 `json:"id"`
+	Version int                    `json:"version"`
+}
+
+// =============================================================================
+
+// Create generates a new private key and stores it in the specified file
+// encrypted with the passphrase.
+func Create(path string, passphrase string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Store(path, privateKey, passphrase); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+// Import reads the plaintext private key from the ecdsa file and stores it
+// in the specified file encrypted with the passphrase.
+func Import(ecdsaPath string, path string, passphrase string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := crypto.LoadECDSA(ecdsaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Store(path, privateKey, passphrase); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+// Export unlocks the private key in the specified file and writes it in
+// plaintext to the ecdsa file.
+func Export(path string, passphrase string, ecdsaPath string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := Unlock(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(ecdsaPath); err == nil {
+		return nil, fmt.Errorf("file %q already exists", ecdsaPath)
+	}
+
+	if err := crypto.SaveECDSA(ecdsaPath, privateKey); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+// Unlock reads the specified file and decrypts the private key with the
+// passphrase.
+func Unlock(path string, passphrase string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decrypt(data, passphrase)
+}
+
+// Store encrypts the private key with the passphrase and writes it to the
+// specified file. An existing file is never replaced, so a key can't be
+// lost by mistake.
+func Store(path string, privateKey *ecdsa.PrivateKey, passphrase string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file %q already exists", path)
+	}
+
+	data, err := Encrypt(privateKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data)
+}
+
+// StoreSecret encrypts the secret with the passphrase and writes it to the
+// specified file. An existing file is never replaced.
+func StoreSecret(path string, secret []byte, passphrase string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file %q already exists", path)
+	}
+
+	cj, err := ethkeystore.EncryptDataV3(secret, []byte(passphrase), ethkeystore.StandardScryptN, ethkeystore.StandardScryptP)
+	if err != nil {
+		return err
+	}
+
+	kf := keyFile{
+		Crypto:  cj,
+		ID:      uuid.NewString(),
+		Version: version,
+	}
+
+	data, err := json.MarshalIndent(kf, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data)
+}
+
+// UnlockSecret reads the specified file and decrypts the secret with the
+// passphrase.
+func UnlockSecret(path string, passphrase string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, err
+	}
+
+	if err := checkParams(kf); err != nil {
+		return nil, err
+	}
+
+	return ethkeystore.DecryptDataV3(kf.Crypto, passphrase)
+}
+
+// Account reads the account for the key in the specified file. The account
+// is stored in plaintext so the passphrase isn't needed.
+func Account(path string) (storage.Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return "", err
+	}
+
+	if !storage.Account(kf.Address).IsAccount() {
+		return "", fmt.Errorf("invalid account %q", kf.Address)
+	}
+
+	return storage.Account(common.HexToAddress(kf.Address).Hex()), nil
+}
+
+// ReadPassphrase reads a passphrase from the specified file. A trailing
+// newline is not part of the passphrase.
+func ReadPassphrase(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// =============================================================================
+
+// Encrypt encrypts the private key with the passphrase and returns the
+// contents of a keystore file.
+func Encrypt(privateKey *ecdsa.PrivateKey, passphrase string) ([]byte, error) {
+	key := ethkeystore.Key{
+		Id:         uuid.New(),
+		Address:    crypto.PubkeyToAddress(privateKey.PublicKey),
+		PrivateKey: privateKey,
+	}
+
+	return ethkeystore.EncryptKey(&key, passphrase, ethkeystore.StandardScryptN, ethkeystore.StandardScryptP)
+}
+
+// Decrypt decrypts the private key in the contents of a keystore file with
+// the passphrase. Keys derived with either scrypt or pbkdf2 are supported.
+func Decrypt(data []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, err
+	}
+
+	if err := checkParams(kf); err != nil {
+		return nil, err
+	}
+
+	key, err := ethkeystore.DecryptKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	address := hex.EncodeToString(key.Address.Bytes())
+	if kf.Address != "" && !strings.EqualFold(strings.TrimPrefix(kf.Address, "0x"), address) {
+		return nil, fmt.Errorf("key doesn't match the account %q", kf.Address)
+	}
+
+	return key.PrivateKey, nil
+}
+
+// =============================================================================
+
+// writeFile writes the contents of a keystore file. The data is written to
+// a temporary file first so a crash never leaves a partial file behind.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// checkParams checks the keystore file is a version the go-ethereum keystore
+// package can decrypt and bounds the key derivation parameters, since those
+// are used as is to derive the key.
+func checkParams(kf keyFile) error {
+	if kf.Version != version {
+		return fmt.Errorf("unsupported version %d", kf.Version)
+	}
+
+	cj := kf.Crypto
+
+	dkLen := kdfInt(cj.KDFParams, "dklen")
+	if dkLen < 32 || dkLen > maxDKLen {
+		return fmt.Errorf("invalid kdf dklen %d", dkLen)
+	}
+
+	switch cj.KDF {
+	case "scrypt":
+		n := kdfInt(cj.KDFParams, "n")
+		r := kdfInt(cj.KDFParams, "r")
+		p := kdfInt(cj.KDFParams, "p")
+
+		// Scrypt needs 128*n*r bytes of memory to derive the key.
+		if n < 2 || n > maxScryptN || n&(n-1) != 0 {
+			return fmt.Errorf("invalid scrypt n %d", n)
+		}
+		if r < 1 || r > maxScryptR || p < 1 || p > maxScryptP {
+			return fmt.Errorf("invalid scrypt r %d, p %d", r, p)
+		}
+		if 128*n*r > maxScryptMemory {
+			return fmt.Errorf("scrypt n %d, r %d needs too much memory", n, r)
+		}
+		return nil
+
+	case "pbkdf2":
+		c := kdfInt(cj.KDFParams, "c")
+		if c < 1 || c > maxPBKDF2C {
+			return fmt.Errorf("invalid pbkdf2 c %d", c)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported kdf %q", cj.KDF)
+}
+
+// kdfInt returns the integer value of the named kdf parameter. JSON numbers
+// are decoded as floats. A missing, fractional or out of range value returns
+// 0 so it fails the bounds checks.
+func kdfInt(params map[string]interface{}, name string) int {
+	f, _ := params[name].(float64)
+	if f != math.Trunc(f) || f < 0 || f > math.MaxInt32 {
+		return 0
+	}
+	return int(f)
+}
@@ -0,0 +1,127 @@
+package keystore_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// specKey is the pbkdf2 test vector from the Web3 Secret Storage definition,
+// encrypted with the passphrase "testpassword".
+const specKey = `{
+	"crypto": {
+		"cipher": "aes-128-ctr",
+		"cipherparams": {"iv": "6087dab2f9fdbbfaddc31a909735c1e6"},
+		"ciphertext": "5318b4d5bcd28de64ee5559e671353e16f075ecae9f99c7a79a38af5f869aa46",
+		"kdf": "pbkdf2",
+		"kdfparams": {"c": 262144, "dklen": 32, "prf": "hmac-sha256", "salt": "ae3cd4e7013836a3df6bd7241b12db061dbe2c6785853cce422d148a624ce0bd"},
+		"mac": "517ead924a9d0dc3124507e3393d175ce3ff7c1e96529c6c555ce9e51205e9b2"
+	},
+	"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+	"version": 3
+}`
+
+func TestStoreUnlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kennedy.json")
+	const passphrase = "correct horse battery staple"
+
+	t.Log("Given the need to keep a private key encrypted on disk.")
+	{
+		t.Logf("\tTest 0:\tWhen storing a new key.")
+		{
+			privateKey, err := keystore.Create(path, passphrase)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to create the key: %s", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould be able to create the key.", success)
+
+			account, err := keystore.Account(path)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to read the account: %s", failed, err)
+			}
+			if exp := storage.PublicKeyToAccount(privateKey.PublicKey); account != exp {
+				t.Fatalf("\t%s\tTest 0:\tShould read the account of the key: got %s, exp %s", failed, account, exp)
+			}
+			t.Logf("\t%s\tTest 0:\tShould read the account of the key.", success)
+
+			unlocked, err := keystore.Unlock(path, passphrase)
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to unlock the key: %s", failed, err)
+			}
+			if !unlocked.Equal(privateKey) {
+				t.Fatalf("\t%s\tTest 0:\tShould unlock the same key.", failed)
+			}
+			t.Logf("\t%s\tTest 0:\tShould unlock the same key.", success)
+
+			if _, err := keystore.Unlock(path, "wrong"); !errors.Is(err, keystore.ErrDecrypt) {
+				t.Fatalf("\t%s\tTest 0:\tShould not unlock the key with the wrong passphrase: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould not unlock the key with the wrong passphrase.", success)
+
+			if _, err := keystore.Create(path, passphrase); err == nil {
+				t.Fatalf("\t%s\tTest 0:\tShould not replace an existing key.", failed)
+			}
+			t.Logf("\t%s\tTest 0:\tShould not replace an existing key.", success)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	const expKey = "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+
+	t.Log("Given the need to read keys written by Ethereum wallets.")
+	{
+		t.Logf("\tTest 0:\tWhen decrypting the specification test vector.")
+		{
+			privateKey, err := keystore.Decrypt([]byte(specKey), "testpassword")
+			if err != nil {
+				t.Fatalf("\t%s\tTest 0:\tShould be able to decrypt the key: %s", failed, err)
+			}
+			if got := hex.EncodeToString(crypto.FromECDSA(privateKey)); got != expKey {
+				t.Fatalf("\t%s\tTest 0:\tShould get the expected key: got %s, exp %s", failed, got, expKey)
+			}
+			t.Logf("\t%s\tTest 0:\tShould get the expected key.", success)
+
+			if _, err := keystore.Decrypt([]byte(specKey), "wrong"); !errors.Is(err, keystore.ErrDecrypt) {
+				t.Fatalf("\t%s\tTest 0:\tShould not decrypt the key with the wrong passphrase: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould not decrypt the key with the wrong passphrase.", success)
+		}
+
+		tt := []struct {
+			name    string
+			changes []string
+		}{
+			{"pbkdf2 iterations", []string{`"c": 262144`, `"c": 1000000000`}},
+			{"derived key length", []string{`"dklen": 32`, `"dklen": 100000000`}},
+			{"scrypt cost", []string{`"kdf": "pbkdf2"`, `"kdf": "scrypt"`, `"c": 262144`, `"n": 1073741824, "r": 8, "p": 1`}},
+			{"scrypt memory", []string{`"kdf": "pbkdf2"`, `"kdf": "scrypt"`, `"c": 262144`, `"n": 1048576, "r": 16, "p": 1`}},
+		}
+
+		for testID, test := range tt {
+			testID++
+			t.Logf("\tTest %d:\tWhen the file asks for an unbounded %s.", testID, test.name)
+			{
+				data := strings.NewReplacer(test.changes...).Replace(specKey)
+
+				_, err := keystore.Decrypt([]byte(data), "testpassword")
+				if err == nil || errors.Is(err, keystore.ErrDecrypt) {
+					t.Fatalf("\t%s\tTest %d:\tShould reject the parameters: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould reject the parameters: %s", success, testID, err)
+			}
+		}
+	}
+}
@@ -1,5 +1,8 @@
 // Package nameservice reads the zblock/accounts folder and creates a name
-// service lookup for the ardan accounts.
+// service lookup for the ardan accounts. Accounts are read from plaintext
+// .ecdsa key files and from .json keystore files, which don't need to be
+// unlocked since the account is stored in plaintext. Other .json files in the
+// folder are skipped.
 package nameservice
 
 import (
@@ -10,36 +13,57 @@ import (
 	"strings"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/keystore"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// EventHandler defines a function that is called when a file in the folder
+// is skipped.
+type EventHandler func(v string, args ...interface{})
+
 // NameService maintains a map of accounts for name lookup.
 type NameService struct {
 	accounts map[storage.Account]string
 }
 
 // New constructs an Ardan Name Service with accounts from the zblock/accounts folder.
-func New(root string) (*NameService, error) {
+func New(root string, evHandler EventHandler) (*NameService, error) {
 	ns := NameService{
 		accounts: make(map[storage.Account]string),
 	}
 
+	// Build a safe event handler function for use.
+	ev := func(v string, args ...interface{}) {
+		if evHandler != nil {
+			evHandler(v, args...)
+		}
+	}
+
 	fn := func(fileName string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("walkdir failure: %w", err)
 		}
 
-		if path.Ext(fileName) != ".ecdsa" {
-			return nil
-		}
+		var account storage.Account
+		switch path.Ext(fileName) {
+		case ".ecdsa":
+			privateKey, err := crypto.LoadECDSA(fileName)
+			if err != nil {
+				return err
+			}
+			account = storage.PublicKeyToAccount(privateKey.PublicKey)
 
-		privateKey, err := crypto.LoadECDSA(fileName)
-		if err != nil {
-			return err
+		case ".json":
+			if account, err = keystore.Account(fileName); err != nil {
+				ev("nameservice: skipping %s: not a keystore file: %s", fileName, err)
+				return nil
+			}
+
+		default:
+			return nil
 		}
 
-		account := storage.PublicKeyToAccount(privateKey.PublicKey)
-		ns.accounts[account] = strings.TrimSuffix(path.Base(fileName), ".ecdsa")
+		ns.accounts[account] = strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
 
 		return nil
 	}
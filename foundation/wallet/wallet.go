@@ -0,0 +1,109 @@
+// Package wallet derives many accounts from a single mnemonic phrase, so one
+// backup phrase recovers all of them. The phrase is generated and turned
+// into a seed as described by BIP-39, and keys are derived from the seed as
+// described by BIP-32. Accounts use the Ethereum BIP-44 path
+// m/44'/60'/0'/0/index, so the same phrase produces the same accounts in
+// Ethereum wallets.
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip32"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// ErrInvalidMnemonic is returned when a mnemonic phrase has an unknown word
+// or a bad checksum.
+var ErrInvalidMnemonic = errors.New("invalid mnemonic phrase")
+
+// entropyBits is the amount of randomness in a new mnemonic phrase, which
+// produces 24 words.
+const entropyBits = 256
+
+// Set of indexes for the levels of the BIP-44 path above the accounts.
+const (
+	purpose  = bip32.FirstHardenedChild + 44
+	coinType = bip32.FirstHardenedChild + 60
+	account  = bip32.FirstHardenedChild + 0
+	change   = 0
+)
+
+// =============================================================================
+
+// NewMnemonic generates a new random mnemonic phrase.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(entropyBits)
+	if err != nil {
+		return "", err
+	}
+
+	return bip39.NewMnemonic(entropy)
+}
+
+// =============================================================================
+
+// Wallet derives the private keys for the accounts of a mnemonic phrase.
+type Wallet struct {
+	key *bip32.Key
+}
+
+// New constructs a wallet for the mnemonic phrase. The passphrase is
+// optional and produces an entirely different set of accounts for the same
+// phrase.
+func New(mnemonic string, passphrase string) (*Wallet, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, ErrInvalidMnemonic
+	}
+
+	master, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the key at m/44'/60'/0'/0 once, since every account is a
+	// child of it.
+	key := master
+	for _, idx := range []uint32{purpose, coinType, account, change} {
+		if key, err = key.NewChildKey(idx); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Wallet{key: key}, nil
+}
+
+// PrivateKey derives the private key for the account at the specified index.
+func (w *Wallet) PrivateKey(index uint32) (*ecdsa.PrivateKey, error) {
+	if index >= bip32.FirstHardenedChild {
+		return nil, fmt.Errorf("invalid index %d", index)
+	}
+
+	child, err := w.key.NewChildKey(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.ToECDSA(child.Key)
+}
+
+// Account derives the account at the specified index.
+func (w *Wallet) Account(index uint32) (storage.Account, error) {
+	privateKey, err := w.PrivateKey(index)
+	if err != nil {
+		return "", err
+	}
+
+	return storage.PublicKeyToAccount(privateKey.PublicKey), nil
+}
+
+// Path returns the BIP-44 derivation path for the account at the specified
+// index.
+func Path(index uint32) string {
+	return fmt.Sprintf("m/44'/60'/0'/0/%d", index)
+}
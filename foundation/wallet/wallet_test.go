@@ -0,0 +1,72 @@
+package wallet_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/wallet"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// mnemonic is the standard test phrase, whose accounts are the same in every
+// Ethereum wallet.
+const mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestAccount(t *testing.T) {
+	tt := []struct {
+		index   uint32
+		path    string
+		account storage.Account
+	}{
+		{0, "m/44'/60'/0'/0/0", "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+		{1, "m/44'/60'/0'/0/1", "0x6Fac4D18c912343BF86fa7049364Dd4E424Ab9C0"},
+		{2, "m/44'/60'/0'/0/2", "0xb6716976A3ebe8D39aCEB04372f22Ff8e6802D7A"},
+	}
+
+	t.Log("Given the need to derive the same accounts as other Ethereum wallets.")
+	{
+		w, err := wallet.New(mnemonic, "")
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to construct the wallet: %s", failed, err)
+		}
+
+		for testID, test := range tt {
+			t.Logf("\tTest %d:\tWhen deriving the account at %s.", testID, test.path)
+			{
+				if path := wallet.Path(test.index); path != test.path {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected path: got %s, exp %s", failed, testID, path, test.path)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected path.", success, testID)
+
+				account, err := w.Account(test.index)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to derive the account: %s", failed, testID, err)
+				}
+				if account != test.account {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected account: got %s, exp %s", failed, testID, account, test.account)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected account.", success, testID)
+			}
+		}
+	}
+}
+
+func TestInvalidMnemonic(t *testing.T) {
+	t.Log("Given the need to reject a mistyped mnemonic phrase.")
+	{
+		t.Logf("\tTest 0:\tWhen the checksum doesn't match.")
+		{
+			bad := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"
+			if _, err := wallet.New(bad, ""); !errors.Is(err, wallet.ErrInvalidMnemonic) {
+				t.Fatalf("\t%s\tTest 0:\tShould reject the phrase: %v", failed, err)
+			}
+			t.Logf("\t%s\tTest 0:\tShould reject the phrase.", success)
+		}
+	}
+}